@@ -0,0 +1,161 @@
+/*****************************************************************/
+/* archive.go -- A decoded base64 TGZ archive kept in memory     */
+/* for repeated extractions.                                     */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Archive is a base64 TGZ archive that has been decoded and decompressed
+// once, so files can be looked up repeatedly without re-reading the archive.
+// Names are matched as by ExtractFile, and if the archive holds several
+// entries with the same name, the first one wins, as it does there.
+//
+// An Archive is never modified after NewArchive returns, so File, List, and
+// Stat may be called from any number of goroutines at once.  They return
+// copies, so callers are free to modify the results.
+type Archive struct {
+	name    string
+	data    []byte // every file body, back to back
+	entries []archiveEntry
+	index   map[string]int // fsName of entry name -> position in entries
+}
+
+// archiveEntry is one regular file or hardlink of an Archive.
+type archiveEntry struct {
+	header tar.Header
+	off    int // start of the body in Archive.data
+	size   int
+	err    error // set for a hardlink that does not lead to an earlier file
+}
+
+// NewArchive(*string, string, ...Option) -- Decodes a base64 TGZ archive into memory.
+// Every regular file body is kept, so the Archive is roughly as large as the
+// uncompressed archive.  Hardlinks share the body of the earlier file they
+// link to.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         *Archive		-- decoded archive
+//         err			-- Present only if error is encountered
+func NewArchive(archive *string, archiveName string, opts ...Option) (*Archive, error) {
+	a := &Archive{name: archiveName, index: make(map[string]int)}
+	var buf bytes.Buffer
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		var entry archiveEntry
+		switch {
+		case fileHeader.Typeflag == tar.TypeLink:
+			entry = a.link(fileHeader)
+		case isRegular(fileHeader):
+			off := buf.Len()
+			n, err := buf.ReadFrom(r)
+			if err != nil {
+				return err
+			}
+			entry = archiveEntry{header: *fileHeader, off: off, size: int(n)}
+		default:
+			return nil
+		}
+		name := fsName(fileHeader.Name)
+		if _, seen := a.index[name]; !seen {
+			a.index[name] = len(a.entries)
+		}
+		a.entries = append(a.entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.data = buf.Bytes()
+	return a, nil
+}
+
+// link(*tar.Header) -- Returns the entry for a hardlink, sharing the body of its target
+func (a *Archive) link(fileHeader *tar.Header) archiveEntry {
+	entry := archiveEntry{header: *fileHeader}
+	i, ok := a.index[fsName(fileHeader.Linkname)]
+	if !ok {
+		entry.err = &ExtractError{Op: OpLink, ArchiveName: a.name, FilePath: fileHeader.Name, Err: fmt.Errorf("hardlink points to %q, which is not an earlier file in the archive", fileHeader.Linkname)}
+		return entry
+	}
+	target := a.entries[i]
+	entry.off, entry.size, entry.err = target.off, target.size, target.err
+	return entry
+}
+
+// File(string) -- Returns the contents of a regular file in the archive.
+// Input:
+//        filePath     string		-- path of the file within the archive
+// Output:
+//         []byte		-- File data, a copy owned by the caller
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive,
+//                         or has OpLink for a hardlink without an earlier target
+func (a *Archive) File(filePath string) ([]byte, error) {
+	i, ok := a.index[fsName(filePath)]
+	if !ok {
+		return nil, notFound(a.name, filePath)
+	}
+	entry := a.entries[i]
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	fileData := make([]byte, entry.size)
+	copy(fileData, a.data[entry.off:entry.off+entry.size])
+	return fileData, nil
+}
+
+// Stat(string) -- Returns a copy of the tar header of a regular file in the archive.
+// Input:
+//        filePath     string		-- path of the file within the archive
+// Output:
+//         *tar.Header		-- Header of the file
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func (a *Archive) Stat(filePath string) (*tar.Header, error) {
+	i, ok := a.index[fsName(filePath)]
+	if !ok {
+		return nil, notFound(a.name, filePath)
+	}
+	header := a.entries[i].header
+	if header.PAXRecords != nil {
+		header.PAXRecords = make(map[string]string, len(a.entries[i].header.PAXRecords))
+		for k, v := range a.entries[i].header.PAXRecords {
+			header.PAXRecords[k] = v
+		}
+	}
+	return &header, nil
+}
+
+// List() -- Returns the names of the archive's regular files and hardlinks in archive order, duplicates included
+func (a *Archive) List() []string {
+	names := make([]string, len(a.entries))
+	for i, entry := range a.entries {
+		names[i] = entry.header.Name
+	}
+	return names
+}
@@ -0,0 +1,111 @@
+/*****************************************************************/
+/* archive_test.go -- Tests and benchmarks the in-memory Archive */
+/* of a base64 TGZ archive.                                      */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// manyFiles(testing.TB, int, int) -- Builds an archive of n files of size bytes each, named file000 on
+func manyFiles(t testing.TB, n, size int) (*string, []string) {
+	t.Helper()
+	entries := make([]entry, n)
+	names := make([]string, n)
+	for i := range entries {
+		names[i] = fmt.Sprintf("dir/file%03d", i)
+		entries[i] = fileEntry(names[i], strings.Repeat(string(rune('a'+i%26)), size))
+	}
+	return fixture(t, entries...), names
+}
+
+// TestArchiveConcurrent is meant for go test -race: an Archive must be safe
+// to share between goroutines once NewArchive returns.
+func TestArchiveConcurrent(t *testing.T) {
+	archive, names := manyFiles(t, 64, 256)
+	a, err := NewArchive(archive, "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				name := names[(g+i)%len(names)]
+				data, err := a.File(name)
+				if err != nil {
+					errs <- err
+					return
+				}
+				// Callers own the copy, so scribbling on it must not show up elsewhere.
+				want := byte('a' + (g+i)%len(names)%26)
+				if len(data) != 256 || data[0] != want {
+					errs <- fmt.Errorf("File(%q) = %.8q..., want 256 bytes of %q", name, data, want)
+					return
+				}
+				data[0] = 'X'
+				if _, err := a.Stat(name); err != nil {
+					errs <- err
+					return
+				}
+				_ = a.List()
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkLookups compares reading files from one Archive with repeated
+// ExtractFile calls, which decode and decompress the archive every time.
+func BenchmarkLookups(b *testing.B) {
+	archive, names := manyFiles(b, 100, 4<<10)
+	b.Run("Archive.File", func(b *testing.B) {
+		a, err := NewArchive(archive, "bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := a.File(names[i%len(names)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ExtractFile", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ExtractFile(archive, "bench", names[i%len(names)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
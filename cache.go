@@ -0,0 +1,118 @@
+/*****************************************************************/
+/* cache.go -- A bounded, least-recently-used set of decoded     */
+/* base64 TGZ archives.                                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps up to a fixed number of decoded Archives, keyed by archive
+// name, and evicts the least recently used one to make room.  Archives are
+// decoded on first use.  A Cache is safe for concurrent use.
+type Cache struct {
+	mu          sync.Mutex
+	maxArchives int
+	lru         *list.List               // front = most recently used; values are *cacheEntry
+	entries     map[string]*list.Element // archive name -> element in lru
+}
+
+// cacheEntry is one decoded archive in a Cache.
+type cacheEntry struct {
+	name    string
+	archive *Archive
+}
+
+// NewCache(int) -- Returns an empty Cache holding at most maxArchives archives
+// Input:
+//        maxArchives  int		-- most archives kept decoded; values below 1 mean 1
+// Output:
+//         *Cache		-- empty cache
+func NewCache(maxArchives int) *Cache {
+	if maxArchives < 1 {
+		maxArchives = 1
+	}
+	return &Cache{maxArchives: maxArchives, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get(*string, string) -- Returns the decoded Archive for archiveName, decoding archive if it is not cached.
+// archive is only read on a miss, so each archiveName must always be given
+// the same archive.  A failed decode is not cached.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- cache key, also used in logs and errors
+// Output:
+//         *Archive		-- decoded archive
+//         err			-- Present only if error is encountered
+func (c *Cache) Get(archive *string, archiveName string) (*Archive, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[archiveName]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry).archive, nil
+	}
+	c.mu.Unlock()
+
+	// Decode without the lock so other archives stay available meanwhile.
+	a, err := NewArchive(archive, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[archiveName]; ok {
+		// Another caller decoded it first; keep theirs.
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).archive, nil
+	}
+	c.entries[archiveName] = c.lru.PushFront(&cacheEntry{name: archiveName, archive: a})
+	for c.lru.Len() > c.maxArchives {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).name)
+	}
+	return a, nil
+}
+
+// Extract(*string, string, string) -- Extracts file from a base64 TGZ archive through the cache.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- cache key, also used in logs and errors
+//        filePath     string		-- path of the file within the archive
+// Output:
+//         []byte		-- File data, a copy owned by the caller
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func (c *Cache) Extract(archive *string, archiveName, filePath string) ([]byte, error) {
+	a, err := c.Get(archive, archiveName)
+	if err != nil {
+		return nil, err
+	}
+	return a.File(filePath)
+}
+
+// Len() -- Returns the number of archives currently cached
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,115 @@
+/*****************************************************************/
+/* cache_test.go -- Tests the cache of decoded base64 TGZ        */
+/* archives.                                                     */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	archives := map[string]*string{
+		"a": fixture(t, fileEntry("name", "a")),
+		"b": fixture(t, fileEntry("name", "b")),
+		"c": fixture(t, fileEntry("name", "c")),
+	}
+	cache := NewCache(2)
+	get := func(name string) *Archive {
+		t.Helper()
+		a, err := cache.Get(archives[name], name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	first := get("a")
+	if get("a") != first {
+		t.Error("second Get decoded the archive again")
+	}
+	get("b")
+	get("a") // b is now the least recently used
+	get("c")
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+	if get("a") != first {
+		t.Error("recently used archive was evicted")
+	}
+	if got, err := cache.Extract(archives["c"], "c", "name"); err != nil || string(got) != "c" {
+		t.Errorf("Extract(c) = %q, %v", got, err)
+	}
+	if _, err := cache.Extract(archives["c"], "c", "nope"); err == nil {
+		t.Error("Extract of a missing file succeeded")
+	}
+
+	corrupt := "!!!!"
+	single := NewCache(0)
+	if _, err := single.Get(&corrupt, "corrupt"); err == nil {
+		t.Fatal("Get of a corrupt archive succeeded")
+	}
+	if single.Len() != 0 {
+		t.Error("failed decode was cached")
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := single.Get(archives[name], name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if single.Len() != 1 {
+		t.Errorf("NewCache(0).Len() = %d after two archives, want 1", single.Len())
+	}
+}
+
+// TestCacheConcurrent is meant for go test -race.
+func TestCacheConcurrent(t *testing.T) {
+	const n = 6
+	archives := make([]*string, n)
+	for i := range archives {
+		archives[i] = fixture(t, fileEntry("name", fmt.Sprint(i)))
+	}
+	cache := NewCache(n / 2)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				k := (g + i) % n
+				got, err := cache.Extract(archives[k], fmt.Sprint(k), "name")
+				if err != nil || string(got) != fmt.Sprint(k) {
+					errs <- fmt.Errorf("Extract(%d) = %q, %v", k, got, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if cache.Len() > n/2 {
+		t.Errorf("Len() = %d, want at most %d", cache.Len(), n/2)
+	}
+}
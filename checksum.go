@@ -0,0 +1,222 @@
+/*****************************************************************/
+/* checksum.go -- Integrity checks for files extracted from      */
+/* a base64 TGZ archive.                                         */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when extracted data does not match its expected hash.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ExtractFileVerify(*string, string, string, string, ...Option) -- Extracts file and checks its SHA-256.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        expectedSHA256Hex string	-- expected SHA-256 of the file, hex encoded
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrChecksumMismatch if the hash differs
+func ExtractFileVerify(archive *string, archiveName, filePath, expectedSHA256Hex string, opts ...Option) ([]byte, error) {
+	expected, err := hex.DecodeString(strings.TrimSpace(expectedSHA256Hex))
+	if err != nil || len(expected) != sha256.Size {
+		return nil, &ExtractError{Op: OpArgument, ArchiveName: archiveName, FilePath: filePath, Err: fmt.Errorf("invalid SHA-256 %q", expectedSHA256Hex)}
+	}
+
+	fileData, err := Extract(archive, archiveName, filePath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(fileData)
+	if string(sum[:]) != string(expected) {
+		return nil, &ExtractError{Op: OpVerify, ArchiveName: archiveName, FilePath: filePath, Err: fmt.Errorf("%w: SHA-256 %x, expected %x", ErrChecksumMismatch, sum, expected)}
+	}
+	return fileData, nil
+}
+
+// ArchiveSHA256(*string, ...Option) -- Returns the SHA-256 of a base64 archive's decoded, still compressed bytes.
+// Input:
+//        archive     *string		-- base64 archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         string		-- SHA-256 of the decoded bytes, hex encoded
+//         err			-- Present only if error is encountered
+func ArchiveSHA256(archive *string, opts ...Option) (string, error) {
+	data, err := decodeBase64(archive, "", optionsConfig(opts))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checksumKeyWords mark custom PAX record keys that hold a checksum.
+var checksumKeyWords = []string{"checksum", "digest", "hash", "sha", "md5", "crc"}
+
+// FileChecksum(*string, string, string, ...Option) -- Returns the checksum PAX records stored with a file.
+// A record is included if its key starts with "SCHILY." or mentions a
+// checksum, e.g. "LIBARCHIVE.sha256" or "custom.digest".  The body is not
+// read or verified.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         map[string]string	-- Matching records keyed by PAX key; empty if
+//                                 the entry has none
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func FileChecksum(archive *string, archiveName, filePath string, opts ...Option) (map[string]string, error) {
+	header, err := StatFile(archive, archiveName, filePath, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]string)
+	for key, value := range header.PAXRecords {
+		if strings.HasPrefix(key, "SCHILY.") || isChecksumKey(key) {
+			records[key] = value
+		}
+	}
+	return records, nil
+}
+
+// isChecksumKey(string) -- Reports whether a PAX key names a checksum
+func isChecksumKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, word := range checksumKeyWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate(*string, string, ...Option) -- Reads a base64 TGZ archive end to end without keeping any data.
+// Every header and body is read, so bad base64, truncation, a corrupt tar
+// header, or a gzip CRC-32 mismatch all surface here.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         err			-- first error encountered, nil if the archive is sound
+func Validate(archive *string, archiveName string, opts ...Option) error {
+	return walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return streamError(OpTar, archiveName, fileHeader.Name, err)
+		}
+		return nil
+	})
+}
+
+// Diff(*string, *string, string, ...Option) -- Compares the entries of two base64 TGZ archives.
+// Regular files are compared by the SHA-256 of their contents, links by
+// their targets, and other entries by type only.  Names are cleaned as in
+// FS, so "./a.txt" and "a.txt" are the same entry.  Each list is sorted.
+// Input:
+//        a           *string		-- older base64 TGZ archive, named archiveName[0] in errors
+//        b           *string		-- newer base64 TGZ archive, named archiveName[1] in errors
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         []string		-- Names only in b
+//         []string		-- Names only in a
+//         []string		-- Names in both whose contents differ
+//         err			-- Present only if error is encountered
+func Diff(a, b *string, archiveName string, opts ...Option) (added, removed, changed []string, err error) {
+	cfg := optionsConfig(opts)
+	before, err := entryDigests(a, archiveName+"[0]", cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	after, err := entryDigests(b, archiveName+"[1]", cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for name, digest := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case old != digest:
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+// entryDigests(*string, string, *config) -- Hashes every entry of an archive for Diff
+// Input:
+//         archive     *string		-- base64 TGZ archive
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+// Output:
+//         map[string][sha256.Size]byte	-- digest of each entry's type, link target, and
+//                                         contents, keyed by fsName of the entry name;
+//                                         the first of several same-named entries wins
+//         err          error		-- set if the archive cannot be read
+func entryDigests(archive *string, archiveName string, cfg *config) (map[string][sha256.Size]byte, error) {
+	digests := make(map[string][sha256.Size]byte)
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		name := fsName(fileHeader.Name)
+		if _, seen := digests[name]; seen {
+			return nil
+		}
+		h := sha256.New()
+		fmt.Fprintf(h, "%c%s\x00", fileHeader.Typeflag, fileHeader.Linkname)
+		if isRegular(fileHeader) {
+			if _, err := io.Copy(h, r); err != nil {
+				return streamError(OpTar, archiveName, fileHeader.Name, err)
+			}
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], h.Sum(nil))
+		digests[name] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
@@ -0,0 +1,239 @@
+/*****************************************************************/
+/* checksum_test.go -- Tests the integrity checks for files      */
+/* extracted from a base64 TGZ archive.                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileChecksum(t *testing.T) {
+	withRecords := func(name string, records map[string]string) entry {
+		e := fileEntry(name, "payload")
+		e.hdr.Format = tar.FormatPAX
+		e.hdr.PAXRecords = records
+		return e
+	}
+	archive := fixture(t,
+		withRecords("signed.bin", map[string]string{
+			"SCHILY.xattr.user.origin": "build",
+			"LIBARCHIVE.sha256":        "239f59...",
+			"custom.Digest":            "abc",
+			"comment":                  "not a checksum",
+		}),
+		withRecords("kinds.bin", map[string]string{
+			"x.MD5": "1", "x.crc32": "2", "x.hash": "3", "x.checksum": "4", "x.note": "5",
+		}),
+		fileEntry("plain.txt", "plain"))
+	tests := []struct {
+		file string
+		want map[string]string
+	}{
+		{file: "signed.bin", want: map[string]string{"SCHILY.xattr.user.origin": "build", "LIBARCHIVE.sha256": "239f59...", "custom.Digest": "abc"}},
+		{file: "kinds.bin", want: map[string]string{"x.MD5": "1", "x.crc32": "2", "x.hash": "3", "x.checksum": "4"}},
+		{file: "plain.txt", want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := FileChecksum(archive, "fixture", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FileChecksum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := FileChecksum(archive, "fixture", "nope", quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+	if got != nil {
+		t.Errorf("got %v alongside the error", got)
+	}
+}
+
+func TestExtractFileVerify(t *testing.T) {
+	archive := fixture(t, fileEntry("payload.bin", "payload"), fileEntry("other.txt", "other"))
+	sum := sha256.Sum256([]byte("payload"))
+	hexSum := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name     string
+		file     string
+		expected string
+		want     []byte
+		wantOp   string
+		wantErr  error
+	}{
+		{name: "match", file: "payload.bin", expected: hexSum, want: []byte("payload")},
+		{name: "upper case", file: "payload.bin", expected: strings.ToUpper(hexSum), want: []byte("payload")},
+		{name: "surrounding space", file: "payload.bin", expected: " " + hexSum + "\n", want: []byte("payload")},
+		{name: "mismatch", file: "other.txt", expected: hexSum, wantOp: OpVerify, wantErr: ErrChecksumMismatch},
+		{name: "not hex", file: "payload.bin", expected: "xyz", wantOp: OpArgument},
+		{name: "too short", file: "payload.bin", expected: hexSum[:62], wantOp: OpArgument},
+		{name: "missing file", file: "nope", expected: hexSum, wantOp: OpNotFound, wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileVerify(archive, "fixture", tt.file, tt.expected, quiet)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+				if got != nil {
+					t.Errorf("got %q alongside the error, want nil", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveSHA256(t *testing.T) {
+	tarData := tarBytes(t, fileEntry("a.txt", "alpha"))
+	gzData := gzipBytes(t, tarData)
+	archive := base64.StdEncoding.EncodeToString(gzData)
+	wrapped := archive[:10] + "\n" + archive[10:] + "\n"
+	other := fixture(t, fileEntry("a.txt", "beta"))
+	corrupt := "!!!!" + archive
+	compressedSum := sha256.Sum256(gzData)
+	tarSum := sha256.Sum256(tarData)
+
+	tests := []struct {
+		name    string
+		archive *string
+		want    string
+		wantOp  string
+	}{
+		{name: "compressed bytes", archive: &archive, want: hex.EncodeToString(compressedSum[:])},
+		{name: "line-wrapped base64", archive: &wrapped, want: hex.EncodeToString(compressedSum[:])},
+		{name: "corrupt base64", archive: &corrupt, wantOp: OpDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ArchiveSHA256(tt.archive, quiet)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if got == hex.EncodeToString(tarSum[:]) {
+				t.Error("got the SHA-256 of the decompressed tar")
+			}
+		})
+	}
+
+	otherSum, err := ArchiveSHA256(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherSum == hex.EncodeToString(compressedSum[:]) {
+		t.Error("archives with different contents have the same SHA-256")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := fixture(t, sampleEntries()...)
+	var entries []entry
+	for _, e := range sampleEntries() {
+		switch e.hdr.Name {
+		case "logs/notes.md":
+			continue
+		case "app/readme.txt":
+			e = fileEntry(e.hdr.Name, "hello, world")
+		case "app/latest":
+			e = symlinkEntry(e.hdr.Name, "readme.txt")
+		case "logs/2023-01-02.txt":
+			// Same contents, newer time: not a change.
+			e.hdr.ModTime = e.hdr.ModTime.Add(1e9)
+		}
+		entries = append(entries, e)
+	}
+	after := fixture(t, append(entries, fileEntry("new.txt", "new"), dirEntry("empty/"))...)
+	// The sample entries again, named as "tar cf - ." would name them.
+	var dotted []entry
+	for _, e := range sampleEntries() {
+		e.hdr.Name = "./" + strings.TrimPrefix(e.hdr.Name, "./")
+		dotted = append(dotted, e)
+	}
+
+	tests := []struct {
+		name                    string
+		a, b                    *string
+		added, removed, changed []string
+	}{
+		{name: "changes", a: before, b: after,
+			added: []string{"empty", "new.txt"}, removed: []string{"logs/notes.md"}, changed: []string{"app/latest", "app/readme.txt"}},
+		{name: "reversed", a: after, b: before,
+			added: []string{"logs/notes.md"}, removed: []string{"empty", "new.txt"}, changed: []string{"app/latest", "app/readme.txt"}},
+		{name: "identical", a: before, b: before},
+		{name: "leading ./", a: before, b: fixture(t, dotted...)},
+		{name: "leading ./ with changes", a: fixture(t, dotted...), b: after,
+			added: []string{"empty", "new.txt"}, removed: []string{"logs/notes.md"}, changed: []string{"app/latest", "app/readme.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed, err := Diff(tt.a, tt.b, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range []struct {
+				what      string
+				got, want []string
+			}{{"added", added, tt.added}, {"removed", removed, tt.removed}, {"changed", changed, tt.changed}} {
+				if !equalStrings(l.got, l.want) {
+					t.Errorf("%s = %q, want %q", l.what, l.got, l.want)
+				}
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	if _, _, _, err := Diff(before, &corrupt, "fixture"); err == nil {
+		t.Error("Diff against a corrupt archive succeeded")
+	}
+}
+
+// equalStrings([]string, []string) -- Reports whether a and b hold the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
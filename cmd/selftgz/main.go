@@ -0,0 +1,123 @@
+/*****************************************************************/
+/* main.go -- selftgz, a command-line front end for              */
+/* go-selftgz that packs, lists, and extracts base64 TGZ         */
+/* archives.                                                     */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	SelfTGZ "github.com/casnix/go-selftgz"
+)
+
+const usage = `usage: selftgz [-level n] <command> [arguments]
+
+commands:
+  pack <dir>                print <dir> as a base64 TGZ string
+  list <file>               print the entry names of the base64 TGZ in <file>
+  extract <file> <path>     write entry <path> of the base64 TGZ in <file> to stdout
+
+flags:
+  -level n                  gzip level used by pack, 1 (fastest) to 9 (smallest)
+`
+
+var level = flag.Int("level", gzip.DefaultCompression, "")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "selftgz:", err)
+		os.Exit(1)
+	}
+}
+
+// run([]string) -- Dispatches a selftgz subcommand
+// Input:
+//         args []string		-- command-line arguments after the flags
+// Output:
+//         err          error		-- set if the command fails or is malformed
+func run(args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "pack":
+		if len(args) != 1 {
+			return fmt.Errorf("pack takes 1 argument, got %d", len(args))
+		}
+		archive, err := SelfTGZ.PackDirLevel(args[0], *level)
+		if err != nil {
+			return err
+		}
+		fmt.Println(archive)
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("list takes 1 argument, got %d", len(args))
+		}
+		archive, err := readArchive(args[0])
+		if err != nil {
+			return err
+		}
+		names, err := SelfTGZ.ListFiles(&archive, args[0], SelfTGZ.WithSilent())
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case "extract":
+		if len(args) != 2 {
+			return fmt.Errorf("extract takes 2 arguments, got %d", len(args))
+		}
+		archive, err := readArchive(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := SelfTGZ.Extract(&archive, args[0], args[1], SelfTGZ.WithSilent())
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// readArchive(string) -- Reads a base64 archive from a file
+func readArchive(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
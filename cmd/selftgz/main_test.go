@@ -0,0 +1,136 @@
+/*****************************************************************/
+/* main_test.go -- Tests the selftgz command.                    */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// capture(*testing.T, []string) -- Calls run(args) and returns what it wrote to stdout
+func capture(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+	runErr := run(args)
+	os.Stdout = stdout
+	w.Close()
+	return <-out, runErr
+}
+
+// packFixture(*testing.T) -- Packs a small directory tree and returns the path of the base64 file
+func packFixture(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	for name, body := range map[string]string{"a.txt": "alpha\n", "sub/b.txt": "beta\n"} {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive, err := capture(t, "pack", src)
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	b64File := filepath.Join(root, "archive.b64")
+	if err := ioutil.WriteFile(b64File, []byte(archive), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return b64File
+}
+
+func TestRun(t *testing.T) {
+	b64File := packFixture(t)
+	tests := []struct {
+		name    string
+		args    []string
+		want    string // expected stdout
+		wantErr string // substring of the expected error
+	}{
+		{name: "list", args: []string{"list", b64File}, want: "a.txt\nsub/\nsub/b.txt\n"},
+		{name: "extract", args: []string{"extract", b64File, "sub/b.txt"}, want: "beta\n"},
+		{name: "extract missing", args: []string{"extract", b64File, "nope"}, wantErr: "file not found"},
+		{name: "list unreadable", args: []string{"list", filepath.Join(filepath.Dir(b64File), "missing")}, wantErr: "no such file"},
+		{name: "no command", args: nil, wantErr: "missing command"},
+		{name: "unknown command", args: []string{"frobnicate"}, wantErr: "unknown command"},
+		{name: "pack arguments", args: []string{"pack"}, wantErr: "pack takes 1 argument"},
+		{name: "list arguments", args: []string{"list", "a", "b"}, wantErr: "list takes 1 argument"},
+		{name: "extract arguments", args: []string{"extract", b64File}, wantErr: "extract takes 2 arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := capture(t, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("run(%q) error = %v, want one containing %q", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("run(%q) = %q, %v; want %q", tt.args, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPackLevel(t *testing.T) {
+	src := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("alpha\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved int) { *level = saved }(*level)
+	tests := []struct {
+		level   int
+		wantErr bool
+	}{
+		{level: 1},
+		{level: 9},
+		{level: 42, wantErr: true},
+	}
+	for _, tt := range tests {
+		*level = tt.level
+		out, err := capture(t, "pack", src)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("pack -level %d succeeded, want an error", tt.level)
+			}
+			continue
+		}
+		if err != nil || strings.TrimSpace(out) == "" {
+			t.Errorf("pack -level %d = %q, %v", tt.level, out, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+/*****************************************************************/
+/* compress.go -- Decompressors for base64 tar archives that     */
+/* use a compression format other than gzip.                     */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression names an archive compression format, as used by Recompress.
+type Compression int
+
+// Compression formats.  bzip2 can only be decompressed.
+const (
+	CompressionGzip Compression = iota
+	CompressionZstd
+	CompressionBzip2
+	CompressionXz
+	CompressionNone // uncompressed tar
+)
+
+// String() -- Returns the format name used in logs and as ExtractError.Op
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionBzip2:
+		return "bzip2"
+	case CompressionXz:
+		return "xz"
+	case CompressionNone:
+		return "tar"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// decompressor() -- Returns the Decompressor for c
+func (c Compression) decompressor() (Decompressor, error) {
+	switch c {
+	case CompressionGzip:
+		return gunzip, nil
+	case CompressionZstd:
+		return unzstd, nil
+	case CompressionBzip2:
+		return unbzip2, nil
+	case CompressionXz:
+		return unxz, nil
+	case CompressionNone:
+		return untar, nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// compressor(io.Writer) -- Returns a writer that compresses into w in format c
+func (c Compression) compressor(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionBzip2:
+		return nil, errors.New("bzip2 compression is not supported")
+	case CompressionXz:
+		return xz.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ExtractFileZstd(*string, string, string, ...Option) -- Extracts file from a base64 zstd-compressed tar archive.
+// Input:
+//        archive     *string		-- base64 .tar.zst archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileZstd(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = unzstd, OpZstd
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// ExtractFileBzip2(*string, string, string, ...Option) -- Extracts file from a base64 bzip2-compressed tar archive.
+// Input:
+//        archive     *string		-- base64 .tar.bz2 archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileBzip2(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = unbzip2, OpBzip2
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// ExtractFileXz(*string, string, string, ...Option) -- Extracts file from a base64 xz-compressed tar archive.
+// Input:
+//        archive     *string		-- base64 .tar.xz archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileXz(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = unxz, OpXz
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// ExtractFileAuto(*string, string, string, ...Option) -- Extracts file from a base64 tar archive of any supported format.
+// The compression format is detected from the first decoded bytes:
+//         1f 8b		-- gzip
+//         28 b5 2f fd		-- zstd
+//         42 5a 68		-- bzip2 ("BZh")
+//         fd 37 7a 58 5a 00	-- xz
+// Anything else is read as an uncompressed tar archive.
+// Input:
+//        archive     *string		-- base64 archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileAuto(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = autodetect, OpDecompress
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// Magic numbers recognized by autodetect.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte{0x42, 0x5a, 0x68}
+	xzMagic    = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+)
+
+// autodetect(io.Reader) -- Decompressor that picks a format from the stream's magic number
+func autodetect(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// A short or empty stream is left for the tar reader to reject.
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gunzip(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return unzstd(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return unbzip2(br)
+	case bytes.HasPrefix(magic, xzMagic):
+		return unxz(br)
+	default:
+		return untar(br)
+	}
+}
+
+// unzstd(io.Reader) -- Decompressor for zstd archives
+func unzstd(r io.Reader) (io.Reader, error) {
+	r, err := checkMagic(r, zstdMagic)
+	if err != nil {
+		return nil, err
+	}
+	// A single decoder goroutine is plenty for one sequential tar walk.
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+// checkMagic(io.Reader, []byte) -- Checks that a stream starts with magic, without consuming it
+// Decoders that only look at their input once read would otherwise report
+// a stream of the wrong format as a tar error.
+// Input:
+//         r            io.Reader	-- compressed stream
+//         magic      []byte		-- expected leading bytes
+// Output:
+//         io.Reader		-- reader over all of r
+//         err          error		-- set if r cannot be read or does not start with magic
+func checkMagic(r io.Reader, magic []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	got, err := br.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(got, magic) {
+		return nil, errors.New("magic number mismatch")
+	}
+	return br, nil
+}
+
+// unbzip2(io.Reader) -- Decompressor for bzip2 archives
+func unbzip2(r io.Reader) (io.Reader, error) {
+	r, err := checkMagic(r, bzip2Magic)
+	if err != nil {
+		return nil, err
+	}
+	return bzip2.NewReader(r), nil
+}
+
+// unxz(io.Reader) -- Decompressor for xz archives
+func unxz(r io.Reader) (io.Reader, error) {
+	return xz.NewReader(r)
+}
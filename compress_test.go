@@ -0,0 +1,188 @@
+/*****************************************************************/
+/* compress_test.go -- Tests extraction of tar archives in other */
+/* compression formats.                                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2Archive holds a.txt = "bzip2 body\n"; compress/bzip2 cannot write
+// one, so it was made with Python's tarfile and bz2 modules.
+const bzip2Archive = "QlpoOTFBWSZTWVojaqkAAG97gMqQAIBAAX2AAAh0IN5wCAggAFQ0p6g0GTQekGhvVBJQJo0GgAAfcyGoQRehCHcTiTlChAhgeNL9JwcxD2CDKgWM5NnJzlUMzz4Z+7UJ+o84RREA/F3JFOFCQWiNqqQ="
+
+// zstdFixture(*testing.T, ...entry) -- Returns entries as a base64 zstd-compressed tar archive
+func zstdFixture(t *testing.T, entries ...entry) string {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return base64.StdEncoding.EncodeToString(enc.EncodeAll(tarBytes(t, entries...), nil))
+}
+
+// xzFixture(*testing.T, ...entry) -- Returns entries as a base64 xz-compressed tar archive
+func xzFixture(t *testing.T, entries ...entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(tarBytes(t, entries...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestExtractFileZstd(t *testing.T) {
+	archive := zstdFixture(t, sampleEntries()...)
+	tests := []struct {
+		filePath string
+		want     string
+		wantOp   string // Op of the expected *ExtractError, "" for success
+	}{
+		{filePath: "app/readme.txt", want: "hello"},
+		{filePath: "app-b/run.sh", want: "#!/bin/sh\n"},
+		{filePath: "app/copy.json", want: `{"debug":true}`},
+		{filePath: "missing", wantOp: OpNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			got, err := ExtractFileZstd(&archive, "fixture", tt.filePath)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+			} else if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileZstd(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+		})
+	}
+	t.Run("gzipped", func(t *testing.T) {
+		_, err := ExtractFileZstd(fixture(t, sampleEntries()...), "fixture", "app/readme.txt")
+		checkExtractError(t, err, OpZstd, nil)
+	})
+}
+
+func TestExtractFileBzip2(t *testing.T) {
+	bzip2 := bzip2Archive
+	// The same tar, gzipped, for the gzip path to compare against.
+	gzipped := fixture(t, fileEntry("a.txt", "bzip2 body\n"))
+	for _, filePath := range []string{"a.txt", "./a.txt", "missing"} {
+		t.Run(filePath, func(t *testing.T) {
+			got, err := ExtractFileBzip2(&bzip2, "fixture", filePath)
+			want, wantErr := ExtractFile(gzipped, "fixture", filePath)
+			if !bytes.Equal(got, want) || !reflect.DeepEqual(err, wantErr) {
+				t.Errorf("ExtractFileBzip2(%q) = %q, %v; want %q, %v as from ExtractFile", filePath, got, err, want, wantErr)
+			}
+		})
+	}
+	t.Run("gzipped", func(t *testing.T) {
+		_, err := ExtractFileBzip2(gzipped, "fixture", "a.txt")
+		checkExtractError(t, err, OpBzip2, nil)
+	})
+}
+
+func TestExtractFileXz(t *testing.T) {
+	archive := xzFixture(t, sampleEntries()...)
+	tests := []struct {
+		filePath string
+		want     string
+		wantOp   string // Op of the expected *ExtractError, "" for success
+	}{
+		{filePath: "app/readme.txt", want: "hello"},
+		{filePath: "app-b/run.sh", want: "#!/bin/sh\n"},
+		{filePath: "app/copy.json", want: `{"debug":true}`},
+		{filePath: "img/logo", want: "\x89PNG\r\n\x1a\n"},
+		{filePath: "missing", wantOp: OpNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			got, err := ExtractFileXz(&archive, "fixture", tt.filePath)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+			} else if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileXz(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+		})
+	}
+
+	notXz := base64.StdEncoding.EncodeToString([]byte("definitely not xz"))
+	for name, archive := range map[string]*string{
+		"gzipped": fixture(t, sampleEntries()...),
+		"text":    &notXz,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ExtractFileXz(archive, "fixture", "app/readme.txt", quiet)
+			checkExtractError(t, err, OpXz, nil)
+		})
+	}
+}
+
+func TestExtractFileAuto(t *testing.T) {
+	entries := []entry{fileEntry("a.txt", "body\n"), hardlinkEntry("b.txt", "a.txt")}
+	plain := base64.StdEncoding.EncodeToString(tarBytes(t, entries...))
+	tests := []struct {
+		name     string
+		archive  string
+		filePath string
+		want     string
+	}{
+		{name: "gzip", archive: *fixture(t, entries...), filePath: "a.txt", want: "body\n"},
+		{name: "zstd", archive: zstdFixture(t, entries...), filePath: "b.txt", want: "body\n"},
+		{name: "bzip2", archive: bzip2Archive, filePath: "a.txt", want: "bzip2 body\n"},
+		{name: "xz", archive: xzFixture(t, entries...), filePath: "b.txt", want: "body\n"},
+		{name: "tar", archive: plain, filePath: "b.txt", want: "body\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileAuto(&tt.archive, "fixture", tt.filePath)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileAuto(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+			_, err = ExtractFileAuto(&tt.archive, "fixture", "missing")
+			checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+		})
+	}
+	t.Run("unknown format", func(t *testing.T) {
+		// Anything unrecognized is read as tar.
+		text := base64.StdEncoding.EncodeToString([]byte("definitely not an archive"))
+		_, err := ExtractFileAuto(&text, "fixture", "a.txt")
+		checkExtractError(t, err, OpTar, nil)
+	})
+}
+
+func TestCompressionString(t *testing.T) {
+	names := map[Compression]string{CompressionGzip: "gzip", CompressionZstd: "zstd", CompressionBzip2: "bzip2", CompressionXz: "xz", CompressionNone: "tar", Compression(42): "Compression(42)"}
+	for c, want := range names {
+		if got := c.String(); got != want {
+			t.Errorf("Compression(%d).String() = %q, want %q", int(c), got, want)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+/*****************************************************************/
+/* disk.go -- Extracts a base64 TGZ archive stored within        */
+/* your Go code to a directory on disk.                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TwiN/go-color"
+)
+
+// WriterFS is the filesystem ExtractToDir writes into; see WithWriterFS.
+// Names are destDir joined with the entry name using filepath.Join.  If
+// an implementation also has any of the methods
+//     Chmod(name string, mode os.FileMode) error
+//     Chtimes(name string, atime, mtime time.Time) error
+//     Symlink(oldname, newname string) error
+//     Chown(name string, uid, gid int) error
+//     Lstat(name string) (os.FileInfo, error)
+//     Link(oldname, newname string) error
+// ExtractToDir uses them for WithPreserveMode, WithPreserveModTime,
+// symlink entries, WithPreserveOwnership and hardlink entries; otherwise
+// those steps are skipped.  Lstat lets ExtractToDir refuse to write
+// through symlinks; symlink entries are only recreated when both Symlink
+// and Lstat exist.
+type WriterFS interface {
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(name string, perm os.FileMode) error
+}
+
+// chmodFS, chtimesFS, symlinkFS, chownFS, lstatFS and linkFS are the optional WriterFS methods.
+type chmodFS interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+type chtimesFS interface {
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+type symlinkFS interface {
+	Symlink(oldname, newname string) error
+}
+
+type chownFS interface {
+	Chown(name string, uid, gid int) error
+}
+
+type lstatFS interface {
+	Lstat(name string) (os.FileInfo, error)
+}
+
+type linkFS interface {
+	Link(oldname, newname string) error
+}
+
+// osFS is the default WriterFS, backed by package os.
+type osFS struct{}
+
+func (osFS) Create(name string) (io.WriteCloser, error)        { return os.Create(name) }
+func (osFS) MkdirAll(name string, perm os.FileMode) error      { return os.MkdirAll(name, perm) }
+func (osFS) Chmod(name string, mode os.FileMode) error         { return os.Chmod(name, mode) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error { return os.Chtimes(name, atime, mtime) }
+func (osFS) Symlink(oldname, newname string) error             { return os.Symlink(oldname, newname) }
+func (osFS) Chown(name string, uid, gid int) error             { return os.Chown(name, uid, gid) }
+func (osFS) Lstat(name string) (os.FileInfo, error)            { return os.Lstat(name) }
+func (osFS) Link(oldname, newname string) error                { return os.Link(oldname, newname) }
+
+// ExtractToDir(*string, string, string, ...Option) -- Extracts a base64 TGZ archive into a directory.
+// Directories are recreated as needed, regular files are written with the
+// permission bits from their tar header, hardlinks are linked to the file
+// they name, which must have been extracted before them, and symlinks are
+// recreated, after every other entry, when their target stays within
+// destDir.  No entry is written through a symlink, whether it came from the
+// archive or was already in destDir.  Other entry types are skipped.
+// Permission bits are subject to the process umask unless WithPreserveMode
+// is given.  With WithPreserveModTime, files get the access and
+// modification times from their tar header.  WithProgress costs an extra
+// pass over the archive to total the sizes of the files that will be
+// written.  WithPreserveOwnership chowns directories and files to their tar
+// uid and gid; permission errors, as when not running as root, are ignored.
+// WithWriterFS redirects all writes, e.g. into an in-memory filesystem.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        destDir      string		-- directory to extract into
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithStripPrefix, WithFilter
+// Output:
+//         err			-- Present only if error is encountered, including any
+//                         entry whose name would land outside destDir
+func ExtractToDir(archive *string, archiveName, destDir string, opts ...Option) error {
+	cfg := optionsConfig(opts)
+	if err := checkBufferSize(archiveName, cfg); err != nil {
+		return err
+	}
+	fsys := cfg.writerFS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	chmod, _ := fsys.(chmodFS)
+	chtimes, _ := fsys.(chtimesFS)
+	chown, _ := fsys.(chownFS)
+	if !cfg.preserveOwner {
+		chown = nil
+	}
+	symlink, _ := fsys.(symlinkFS)
+	lstat, _ := fsys.(lstatFS)
+	link, _ := fsys.(linkFS)
+
+	var done, total int64
+	if cfg.progress != nil {
+		err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+			if name, _ := dirEntryName(fileHeader, cfg); name != "" && isRegular(fileHeader) {
+				total += fileHeader.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Symlinks wait until every file is written, so no write can follow one.
+	var links []pendingLink
+	written := make(map[string]string) // fsName of each file written -> its path
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		name, missingPrefix := dirEntryName(fileHeader, cfg)
+		if missingPrefix {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Yellow, "WARNING -- SKIPPING "+fileHeader.Name+" WITHOUT PREFIX "+cfg.stripPrefix))
+		}
+		if name == "" {
+			return nil
+		}
+
+		target, err := sanitizeEntryPath(destDir, name)
+		if err != nil {
+			return &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: err}
+		}
+		if fileHeader.Typeflag == tar.TypeSymlink {
+			if symlink == nil || lstat == nil {
+				cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Yellow, "WARNING -- SKIPPING SYMLINK "+fileHeader.Name))
+				return nil
+			}
+			if filepath.IsAbs(fileHeader.Linkname) {
+				return &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("symlink has absolute target %q", fileHeader.Linkname)}
+			}
+			links = append(links, pendingLink{entry: fileHeader.Name, name: name, target: target, linkname: fileHeader.Linkname})
+			return nil
+		}
+		if err := checkEntryPath(lstat, destDir, name); err != nil {
+			return &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: err}
+		}
+
+		mode := os.FileMode(fileHeader.Mode).Perm()
+		switch {
+		case fileHeader.Typeflag == tar.TypeDir:
+			if err := fsys.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+			if err := chownEntry(chown, target, fileHeader); err != nil {
+				return err
+			}
+			if cfg.preserveMode && chmod != nil {
+				return chmod.Chmod(target, mode)
+			}
+			return nil
+		case isRegular(fileHeader):
+			if cfg.progress != nil {
+				r = &progressReader{r: r, done: &done, total: total, progress: cfg.progress}
+			}
+			if err := writeFile(fsys, target, r, mode, fileHeader, archiveName, cfg); err != nil {
+				return err
+			}
+			written[fsName(name)] = target
+			if err := chownEntry(chown, target, fileHeader); err != nil {
+				return err
+			}
+			if cfg.preserveMode && chmod != nil {
+				if err := chmod.Chmod(target, mode); err != nil {
+					return err
+				}
+			}
+			if cfg.preserveModTime && chtimes != nil {
+				atime := fileHeader.AccessTime
+				if atime.IsZero() {
+					atime = fileHeader.ModTime
+				}
+				return chtimes.Chtimes(target, atime, fileHeader.ModTime)
+			}
+			return nil
+		case fileHeader.Typeflag == tar.TypeLink:
+			if link == nil {
+				cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Yellow, "WARNING -- SKIPPING HARDLINK "+fileHeader.Name))
+				return nil
+			}
+			linkname := fileHeader.Linkname
+			if cfg.stripPrefix != "" {
+				linkname, _ = stripEntryPrefix(linkname, cfg.stripPrefix)
+			}
+			oldname, ok := written[fsName(linkname)]
+			if linkname == "" || !ok {
+				return &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("hardlink points to %q, which was not extracted before it", fileHeader.Linkname)}
+			}
+			if err := fsys.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := link.Link(oldname, target); err != nil {
+				return err
+			}
+			written[fsName(name)] = oldname
+			return nil
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	created := make(map[string]bool, len(links))
+	for _, link := range links {
+		if err := checkEntryPath(lstat, destDir, link.name); err != nil {
+			return &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: link.entry, Err: err}
+		}
+		if err := fsys.MkdirAll(filepath.Dir(link.target), 0755); err != nil {
+			return err
+		}
+		if err := checkLinkTarget(lstat, destDir, link.name, link.linkname, created); err != nil {
+			return &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: link.entry, Err: fmt.Errorf("symlink: %w", err)}
+		}
+		if err := symlink.Symlink(link.linkname, link.target); err != nil {
+			return err
+		}
+		created[fsName(link.name)] = true
+	}
+	return nil
+}
+
+// pendingLink is a symlink entry ExtractToDir creates once every file is written.
+type pendingLink struct {
+	entry    string // tar entry name, for errors
+	name     string // entry name relative to destDir
+	target   string // path of the symlink to create
+	linkname string // symlink target, as stored in the archive
+}
+
+// checkEntryPath(lstatFS, string, string) -- Refuses an entry name that passes through a symlink
+// Every component of name, including the last, is checked, so neither
+// parent directories nor the file itself can redirect a write.  Checking
+// stops at the first component that does not exist yet.
+// Input:
+//         fsys         lstatFS		-- filesystem to inspect; nil skips the check
+//         destDir      string		-- destination directory
+//         name         string		-- entry name relative to destDir
+// Output:
+//         err          error		-- set if a component of name is a symlink
+func checkEntryPath(fsys lstatFS, destDir, name string) error {
+	if fsys == nil {
+		return nil
+	}
+	rel := ""
+	for _, part := range strings.Split(fsName(name), "/") {
+		if part == "." {
+			continue
+		}
+		rel = path.Join(rel, part)
+		fi, err := fsys.Lstat(filepath.Join(destDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("entry %q passes through symlink %q", name, rel)
+		}
+	}
+	return nil
+}
+
+// checkLinkTarget(lstatFS, string, string, string, map[string]bool) -- Refuses a symlink whose target could leave destDir
+// The target is walked component by component from the symlink's directory
+// against the tree as it is on disk: ".." may only climb out of a real
+// directory inside destDir, and the path may only descend through symlinks
+// this extraction created, each of which was checked the same way.
+// Input:
+//         fsys         lstatFS			-- filesystem to inspect
+//         destDir      string			-- destination directory
+//         name         string			-- symlink entry name relative to destDir
+//         linkname     string			-- symlink target
+//         created      map[string]bool	-- symlinks created so far, by fsName
+// Output:
+//         err          error			-- set if the target escapes or passes through
+//                                         a foreign symlink
+func checkLinkTarget(fsys lstatFS, destDir, name, linkname string, created map[string]bool) error {
+	var stack []string
+	if dir := path.Dir(fsName(name)); dir != "." {
+		stack = strings.Split(dir, "/")
+	}
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(stack) == 0 {
+				return fmt.Errorf("target %q escapes %q", linkname, destDir)
+			}
+			rel := strings.Join(stack, "/")
+			fi, err := fsys.Lstat(filepath.Join(destDir, filepath.FromSlash(rel)))
+			if err != nil || !fi.IsDir() {
+				return fmt.Errorf("target %q climbs out of %q, which is not a directory", linkname, rel)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			stack = append(stack, part)
+			rel := strings.Join(stack, "/")
+			fi, err := fsys.Lstat(filepath.Join(destDir, filepath.FromSlash(rel)))
+			if err == nil && fi.Mode()&os.ModeSymlink != 0 && !created[rel] {
+				return fmt.Errorf("target %q passes through symlink %q", linkname, rel)
+			}
+		}
+	}
+	return nil
+}
+
+// chownEntry(chownFS, string, *tar.Header) -- Gives target the owner in its tar header, if chown is set
+// Permission errors are ignored, as only root may give files away.
+func chownEntry(chown chownFS, target string, fileHeader *tar.Header) error {
+	if chown == nil {
+		return nil
+	}
+	if err := chown.Chown(target, fileHeader.Uid, fileHeader.Gid); err != nil && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	return nil
+}
+
+// dirEntryName(*tar.Header, *config) -- Applies cfg.filter and cfg.stripPrefix to an entry
+// Input:
+//         fileHeader  *tar.Header	-- entry header
+//         cfg         *config		-- extraction settings
+// Output:
+//         string		-- entry name relative to the destination, "" to skip the entry
+//         bool			-- true if the entry is skipped for lying outside cfg.stripPrefix
+func dirEntryName(fileHeader *tar.Header, cfg *config) (string, bool) {
+	if cfg.filter != nil && !cfg.filter(fileHeader) {
+		return "", false
+	}
+	if cfg.stripPrefix == "" {
+		return fileHeader.Name, false
+	}
+	stripped, ok := stripEntryPrefix(fileHeader.Name, cfg.stripPrefix)
+	return stripped, !ok
+}
+
+// stripEntryPrefix(string, string) -- Removes a leading directory prefix from a tar entry name
+// Leading "./" is ignored on both name and prefix.
+// Input:
+//         name         string		-- tar entry name
+//         prefix       string		-- directory prefix, e.g. "package-1.2.3/"
+// Output:
+//         string		-- name relative to prefix, "" for the prefix directory itself
+//         bool			-- false if name is not under prefix
+func stripEntryPrefix(name, prefix string) (string, bool) {
+	prefix = strings.Trim(path.Clean(prefix), "/")
+	name = strings.TrimPrefix(path.Clean(name), "./")
+	if prefix == "." || prefix == "" {
+		return name, true
+	}
+	if name == prefix {
+		return "", true
+	}
+	if !strings.HasPrefix(name, prefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix+"/"), true
+}
+
+// sanitizeEntryPath(string, string) -- Resolves a tar entry name to a path inside dest
+// Input:
+//         dest         string		-- destination directory
+//         name         string		-- tar entry name
+// Output:
+//         string		-- dest joined with name
+//         err          error		-- set if the joined path is outside dest
+func sanitizeEntryPath(dest, name string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q escapes %q", name, dest)
+	}
+	return target, nil
+}
+
+// writeFile(WriterFS, string, io.Reader, os.FileMode, *tar.Header, string, *config) -- Writes r to a new file, creating parent directories
+// Input:
+//         fsys         WriterFS	-- filesystem to write into
+//         target       string		-- path of the file to create
+//         r            io.Reader	-- file contents
+//         mode         os.FileMode	-- permission bits for the new file; honoured
+//                                     only by the OS filesystem
+//         fileHeader  *tar.Header	-- entry header, checked against cfg.maxSize
+//         archiveName  string		-- archive name used in errors
+//         cfg         *config		-- extraction settings; cfg.bufferSize sizes the copy
+// Output:
+//         err          error		-- set if the file cannot be written; wraps
+//                                 ErrSizeExceeded if the body is over cfg.maxSize
+func writeFile(fsys WriterFS, target string, r io.Reader, mode os.FileMode, fileHeader *tar.Header, archiveName string, cfg *config) error {
+	if err := fsys.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	var f io.WriteCloser
+	var err error
+	if _, ok := fsys.(osFS); ok {
+		f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	} else {
+		f, err = fsys.Create(target)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := copyEntry(f, r, fileHeader, archiveName, cfg); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
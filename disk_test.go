@@ -0,0 +1,746 @@
+/*****************************************************************/
+/* disk_test.go -- Tests extraction of base64 TGZ archives to a  */
+/* directory on disk.                                            */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memFS is a WriterFS that keeps files in memory and records the optional
+// calls ExtractToDir makes, keyed by slash-separated path below its root.
+type memFS struct {
+	root   string
+	files  map[string]*bytes.Buffer
+	dirs   map[string]bool
+	modes  map[string]os.FileMode
+	times  map[string]time.Time
+	owners map[string][2]int
+
+	chownErr error // returned by every Chown
+}
+
+func newMemFS(root string) *memFS {
+	return &memFS{root: root, files: map[string]*bytes.Buffer{}, dirs: map[string]bool{}, modes: map[string]os.FileMode{}, times: map[string]time.Time{}, owners: map[string][2]int{}}
+}
+
+// rel(string) -- Returns name relative to the root, as the maps key it
+func (m *memFS) rel(name string) string {
+	rel, err := filepath.Rel(m.root, name)
+	if err != nil {
+		panic(err)
+	}
+	return filepath.ToSlash(rel)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	buf := new(bytes.Buffer)
+	m.files[m.rel(name)] = buf
+	return nopCloser{buf}, nil
+}
+
+func (m *memFS) MkdirAll(name string, perm os.FileMode) error {
+	m.dirs[m.rel(name)] = true
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode os.FileMode) error {
+	m.modes[m.rel(name)] = mode
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.times[m.rel(name)] = mtime
+	return nil
+}
+
+func (m *memFS) Chown(name string, uid, gid int) error {
+	m.owners[m.rel(name)] = [2]int{uid, gid}
+	return m.chownErr
+}
+
+// contents() -- Returns every file written, as ExtractAll would
+func (m *memFS) contents() map[string][]byte {
+	got := make(map[string][]byte, len(m.files))
+	for name, buf := range m.files {
+		got[name] = append([]byte{}, buf.Bytes()...)
+	}
+	return got
+}
+
+func TestExtractToDirMemFS(t *testing.T) {
+	gitkeep := fileEntry("pkg-1.0/empty/.gitkeep", "")
+	macosx := fileEntry("pkg-1.0/__MACOSX/._run", "resource fork")
+	run := fileEntry("pkg-1.0/bin/run", "#!/bin/sh\n")
+	run.hdr.Mode, run.hdr.Uid, run.hdr.Gid = 04755, 1000, 100
+	run.hdr.AccessTime = fixtureTime.Add(-time.Hour)
+	archive := fixture(t, dirEntry("pkg-1.0/"), run, fileEntry("pkg-1.0/etc/app.conf", "debug = true"),
+		gitkeep, macosx, symlinkEntry("pkg-1.0/bin/app", "run"), hardlinkEntry("pkg-1.0/bin/again", "pkg-1.0/bin/run"),
+		fileEntry("README", "outside the prefix"))
+	noJunk := WithFilter(func(hdr *tar.Header) bool {
+		return !strings.HasSuffix(hdr.Name, "/.gitkeep") && !strings.Contains(hdr.Name, "/__MACOSX/")
+	})
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		want  map[string][]byte
+		check func(t *testing.T, m *memFS)
+	}{
+		{
+			name: "everything",
+			want: files("pkg-1.0/bin/run", "#!/bin/sh\n", "pkg-1.0/etc/app.conf", "debug = true", "pkg-1.0/empty/.gitkeep", "",
+				"pkg-1.0/__MACOSX/._run", "resource fork", "README", "outside the prefix"),
+			check: func(t *testing.T, m *memFS) {
+				// Without the options, none of the optional methods are called.
+				if len(m.modes)+len(m.times)+len(m.owners) != 0 {
+					t.Errorf("modes %v, times %v, owners %v; want none", m.modes, m.times, m.owners)
+				}
+				if !m.dirs["pkg-1.0"] {
+					t.Errorf("directories %v, want pkg-1.0", m.dirs)
+				}
+			},
+		},
+		{
+			name: "preserve mode",
+			opts: []Option{WithStripPrefix("pkg-1.0"), noJunk, WithPreserveMode()},
+			want: files("bin/run", "#!/bin/sh\n", "etc/app.conf", "debug = true"),
+			check: func(t *testing.T, m *memFS) {
+				// Only permission bits are applied, never setuid.
+				if m.modes["bin/run"] != 0755 || m.modes["etc/app.conf"] != 0644 || len(m.modes) != 2 {
+					t.Errorf("modes = %v", m.modes)
+				}
+			},
+		},
+		{
+			name: "preserve modification time",
+			opts: []Option{WithStripPrefix("pkg-1.0"), noJunk, WithPreserveModTime()},
+			want: files("bin/run", "#!/bin/sh\n", "etc/app.conf", "debug = true"),
+			check: func(t *testing.T, m *memFS) {
+				if len(m.times) != 2 || !m.times["bin/run"].Equal(fixtureTime) || !m.times["etc/app.conf"].Equal(fixtureTime) {
+					t.Errorf("times = %v, want %v for both files", m.times, fixtureTime)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "dest")
+			m := newMemFS(root)
+			opts := append([]Option{WithWriterFS(m), quiet}, tt.opts...)
+			if err := ExtractToDir(archive, "fixture", root, opts...); err != nil {
+				t.Fatal(err)
+			}
+			// Symlinks and hardlinks need Lstat, Symlink and Link, which memFS lacks.
+			if got := m.contents(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(root); !os.IsNotExist(err) {
+				t.Errorf("ExtractToDir touched the OS filesystem: %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, m)
+			}
+		})
+	}
+}
+
+func TestStripEntryPrefix(t *testing.T) {
+	tests := []struct {
+		name, prefix string
+		want         string
+		ok           bool
+	}{
+		{name: "pkg/a.txt", prefix: "pkg", want: "a.txt", ok: true},
+		{name: "./pkg/a.txt", prefix: "pkg/", want: "a.txt", ok: true},
+		{name: "pkg/a.txt", prefix: "./pkg/", want: "a.txt", ok: true},
+		{name: "pkg/a.txt", prefix: "/pkg", want: "a.txt", ok: true},
+		{name: "pkg/sub/a.txt", prefix: "pkg/sub", want: "a.txt", ok: true},
+		{name: "pkg/", prefix: "pkg", want: "", ok: true},
+		{name: "pkg/a.txt", prefix: ".", want: "pkg/a.txt", ok: true},
+		{name: "pkg-1.0/a.txt", prefix: "pkg"},
+		{name: "other/a.txt", prefix: "pkg"},
+		{name: "pkg", prefix: "pkg/sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" less "+tt.prefix, func(t *testing.T) {
+			got, ok := stripEntryPrefix(tt.name, tt.prefix)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("stripEntryPrefix(%q, %q) = %q, %t; want %q, %t", tt.name, tt.prefix, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExtractToDirStripPrefix(t *testing.T) {
+	archive := fixture(t, dirEntry("pkg-1.0/"), fileEntry("pkg-1.0/bin/run", "#!/bin/sh\n"), fileEntry("pkg-1.0/etc/app.conf", "debug = true"),
+		symlinkEntry("pkg-1.0/bin/app", "run"), hardlinkEntry("pkg-1.0/bin/again", "pkg-1.0/bin/run"),
+		fileEntry("README", "outside the prefix"), fileEntry("pkg-1.0-extra/x", "outside too"))
+	stripped := files("bin/run", "#!/bin/sh\n", "bin/again", "#!/bin/sh\n", "etc/app.conf", "debug = true")
+	tests := []struct {
+		prefix  string
+		want    map[string][]byte
+		link    string // symlink expected to point at "run", "" if none
+		skipped int    // entries logged as outside the prefix
+	}{
+		{prefix: "pkg-1.0", want: stripped, link: "bin/app", skipped: 2},
+		{prefix: "pkg-1.0/", want: stripped, link: "bin/app", skipped: 2},
+		{prefix: "./pkg-1.0/", want: stripped, link: "bin/app", skipped: 2},
+		{prefix: "pkg-1.0/bin", want: files("run", "#!/bin/sh\n", "again", "#!/bin/sh\n"), link: "app", skipped: 4},
+		// Only whole name elements are stripped.
+		{prefix: "pkg", want: files(), skipped: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			dest := t.TempDir()
+			logger := &recordingLogger{}
+			if err := ExtractToDir(archive, "fixture", dest, WithStripPrefix(tt.prefix), WithLogger(logger)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTree(t, dest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+			if tt.link != "" {
+				if target, err := os.Readlink(filepath.Join(dest, filepath.FromSlash(tt.link))); err != nil || target != "run" {
+					t.Errorf("%s links to %q, %v; want run", tt.link, target, err)
+				}
+			}
+			skipped := 0
+			for _, line := range logger.lines {
+				if strings.Contains(line, "WITHOUT PREFIX") {
+					skipped++
+				}
+			}
+			if skipped != tt.skipped {
+				t.Errorf("logged %d skipped entries, want %d: %q", skipped, tt.skipped, logger.lines)
+			}
+		})
+	}
+}
+
+func TestExtractToDirFilter(t *testing.T) {
+	archive := fixture(t, append(sampleEntries(), fileEntry("app/empty/.gitkeep", ""), fileEntry("__MACOSX/app/._config.json", "fork"))...)
+	tests := []struct {
+		name   string
+		keep   func(hdr *tar.Header) bool
+		strip  string
+		want   map[string][]byte
+		seen   []string // when set, the names the filter is called with
+		noLink bool     // app/latest is filtered out
+	}{
+		{
+			name:   "only .json",
+			keep:   func(hdr *tar.Header) bool { return hdr.Typeflag == tar.TypeDir || path.Ext(hdr.Name) == ".json" },
+			want:   files("app/config.json", `{"debug":true}`, "app/copy.json", `{"debug":true}`, "__MACOSX/app/._config.json", "fork"),
+			noLink: true,
+		},
+		{
+			name: "junk",
+			keep: func(hdr *tar.Header) bool {
+				return path.Base(hdr.Name) != ".gitkeep" && !strings.HasPrefix(hdr.Name, "__MACOSX/")
+			},
+			want: files("app/config.json", `{"debug":true}`, "app/readme.txt", "hello", "app/copy.json", `{"debug":true}`,
+				"app-b/run.sh", "#!/bin/sh\n", "logs/2023-01-02.txt", "jan", "logs/2023-1-2.txt", "odd",
+				"logs/notes.md", "# notes", "img/logo", "\x89PNG\r\n\x1a\n"),
+		},
+		{
+			name:   "over a size",
+			keep:   func(hdr *tar.Header) bool { return hdr.Typeflag == tar.TypeReg && hdr.Size <= 5 },
+			want:   files("app/readme.txt", "hello", "logs/2023-01-02.txt", "jan", "logs/2023-1-2.txt", "odd", "app/empty/.gitkeep", "", "__MACOSX/app/._config.json", "fork"),
+			noLink: true,
+		},
+		{
+			name:   "nothing",
+			keep:   func(*tar.Header) bool { return false },
+			want:   files(),
+			noLink: true,
+		},
+		{
+			name:  "before the prefix is stripped",
+			keep:  func(hdr *tar.Header) bool { return strings.HasPrefix(hdr.Name, "logs/") },
+			strip: "logs",
+			want:  files("2023-01-02.txt", "jan", "2023-1-2.txt", "odd", "notes.md", "# notes"),
+			seen: []string{"app/", "app/config.json", "app/readme.txt", "app/latest", "app/copy.json", "./app-b/run.sh",
+				"logs/2023-01-02.txt", "logs/2023-1-2.txt", "logs/notes.md", "img/logo", "app/empty/.gitkeep", "__MACOSX/app/._config.json"},
+			noLink: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			var seen []string
+			opts := []Option{quiet, WithFilter(func(hdr *tar.Header) bool {
+				seen = append(seen, hdr.Name)
+				return tt.keep(hdr)
+			})}
+			if tt.strip != "" {
+				opts = append(opts, WithStripPrefix(tt.strip))
+			}
+			if err := ExtractToDir(archive, "fixture", dest, opts...); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTree(t, dest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extracted %q, want %q", got, tt.want)
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "app", "latest")); os.IsNotExist(err) != tt.noLink {
+				t.Errorf("app/latest: %v, want it filtered out = %t", err, tt.noLink)
+			}
+			if tt.seen != nil && !equalStrings(seen, tt.seen) {
+				t.Errorf("filter called with %q, want %q", seen, tt.seen)
+			}
+		})
+	}
+}
+
+func TestSanitizeEntryPath(t *testing.T) {
+	dest := filepath.Join("tmp", "dest")
+	tests := []struct {
+		name string
+		want string // "" if the entry escapes
+	}{
+		{name: "a/b.txt", want: filepath.Join(dest, "a", "b.txt")},
+		{name: "./a", want: filepath.Join(dest, "a")},
+		{name: "a/../b", want: filepath.Join(dest, "b")},
+		{name: "/etc/passwd", want: filepath.Join(dest, "etc", "passwd")},
+		{name: ".", want: dest},
+		{name: "..dots", want: filepath.Join(dest, "..dots")},
+		{name: ".."},
+		{name: "../evil"},
+		{name: "../../etc/passwd"},
+		{name: "a/../../evil"},
+		{name: "/../evil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeEntryPath(dest, tt.name)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("sanitizeEntryPath(%q) = %q, want an error", tt.name, got)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("sanitizeEntryPath(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractToDirTraversal(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		want    map[string][]byte // nil if extraction must fail
+	}{
+		{name: "parent in name", entries: []entry{fileEntry("../evil", "x")}},
+		{name: "parent after directory", entries: []entry{fileEntry("a/../../evil", "x")}},
+		{name: "directory outside", entries: []entry{dirEntry("../evil/")}},
+		{name: "hardlink outside", entries: []entry{fileEntry("a", "x"), hardlinkEntry("../evil", "a")}},
+		{name: "absolute name stays inside", entries: []entry{fileEntry("/etc/app.conf", "x")}, want: files("etc/app.conf", "x")},
+		{name: "parent within destination", entries: []entry{fileEntry("a/../b", "x")}, want: files("b", "x")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			err := ExtractToDir(fixture(t, tt.entries...), "fixture", dest, quiet)
+			if tt.want == nil {
+				checkExtractError(t, err, OpTar, nil)
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := readTree(t, dest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil")); err == nil {
+				t.Error("entry written outside the destination")
+			}
+		})
+	}
+}
+
+func TestExtractToDirSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		setup   func(t *testing.T, dest, outside string)
+		wantErr bool
+		want    map[string]string // file contents read back through dest
+	}{
+		{
+			name:    "chain through dot and parent links",
+			entries: []entry{symlinkEntry("a", "."), symlinkEntry("a/b", ".."), fileEntry("b/pwned", "x")},
+			wantErr: true,
+		},
+		{
+			name:    "file under a parent link",
+			entries: []entry{symlinkEntry("evil", ".."), fileEntry("evil/pwned", "x")},
+			wantErr: true,
+		},
+		{
+			name:    "climb out through a created link",
+			entries: []entry{symlinkEntry("a", "."), symlinkEntry("c", "a/../..")},
+			wantErr: true,
+		},
+		{
+			name:    "parent of created link",
+			entries: []entry{symlinkEntry("a", "."), symlinkEntry("c", "a/..")},
+			wantErr: true,
+		},
+		{
+			name:    "absolute target",
+			entries: []entry{symlinkEntry("abs", "/etc/passwd")},
+			wantErr: true,
+		},
+		{
+			name:    "relative escape",
+			entries: []entry{symlinkEntry("sub/up", "../../pwned")},
+			wantErr: true,
+		},
+		{
+			name:    "symlink already in destination",
+			entries: []entry{fileEntry("pre/pwned", "x")},
+			setup: func(t *testing.T, dest, outside string) {
+				if err := os.Symlink(outside, filepath.Join(dest, "pre")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "file replacing a symlink already in destination",
+			entries: []entry{fileEntry("pwned", "x")},
+			setup: func(t *testing.T, dest, outside string) {
+				if err := os.Symlink(filepath.Join(outside, "pwned"), filepath.Join(dest, "pwned")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "links inside destination",
+			entries: []entry{
+				fileEntry("sub/file", "data"),
+				symlinkEntry("lib", "sub"),
+				symlinkEntry("viaLib", "lib/file"),
+				symlinkEntry("sub/up", "../sub/file"),
+			},
+			want: map[string]string{"viaLib": "data", "sub/up": "data", "lib/file": "data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			outside := filepath.Join(root, "outside")
+			for _, dir := range []string{dest, outside} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, dest, outside)
+			}
+
+			err := ExtractToDir(fixture(t, tt.entries...), "fixture", dest, quiet)
+			if tt.wantErr {
+				var extractErr *ExtractError
+				if !errors.As(err, &extractErr) || extractErr.Op != OpTar {
+					t.Fatalf("ExtractToDir() error = %v, want an OpTar *ExtractError", err)
+				}
+			} else if err != nil {
+				t.Fatalf("ExtractToDir() error = %v", err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(root, "pwned")); err == nil {
+				t.Error("file written beside the destination")
+			}
+			if names, _ := ioutil.ReadDir(outside); len(names) != 0 {
+				t.Errorf("files written outside the destination: %v", names)
+			}
+			for name, want := range tt.want {
+				got, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil || string(got) != want {
+					t.Errorf("read %s = %q, %v; want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+// readTree(*testing.T, string) -- Returns the regular files below dir, keyed by slash-separated path
+func readTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	got := make(map[string][]byte)
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		got[filepath.ToSlash(rel)] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestExtractToDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		opts    []Option
+		wantOp  string // Op of the expected *ExtractError, "" for success
+		check   func(t *testing.T, dest string)
+	}{
+		{
+			name:    "tree",
+			entries: sampleEntries(),
+			check: func(t *testing.T, dest string) {
+				want := files("app/config.json", `{"debug":true}`, "app/readme.txt", "hello", "app/copy.json", `{"debug":true}`,
+					"app-b/run.sh", "#!/bin/sh\n", "logs/2023-01-02.txt", "jan", "logs/2023-1-2.txt", "odd",
+					"logs/notes.md", "# notes", "img/logo", "\x89PNG\r\n\x1a\n")
+				if got := readTree(t, dest); !reflect.DeepEqual(got, want) {
+					t.Errorf("regular files = %q, want %q", got, want)
+				}
+				if target, err := os.Readlink(filepath.Join(dest, "app", "latest")); err != nil || target != "config.json" {
+					t.Errorf("app/latest links to %q, %v; want config.json", target, err)
+				}
+				original, _ := os.Stat(filepath.Join(dest, "app", "config.json"))
+				copied, _ := os.Stat(filepath.Join(dest, "app", "copy.json"))
+				if original == nil || copied == nil || !os.SameFile(original, copied) {
+					t.Error("app/copy.json is not a hardlink to app/config.json")
+				}
+				for name, want := range map[string]os.FileMode{"app-b/run.sh": 0755, "app/readme.txt": 0644} {
+					// The umask can only clear bits, so any it leaves set came from the header.
+					fi, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+					if err != nil || fi.Mode().Perm()&^want != 0 || fi.Mode().Perm()&0700 != want&0700 {
+						t.Errorf("%s mode = %v, %v; want %v less the umask", name, fi.Mode(), err, os.FileMode(want))
+					}
+				}
+				for _, dir := range []string{"app", "app-b", "logs", "img"} {
+					if fi, err := os.Stat(filepath.Join(dest, dir)); err != nil || !fi.IsDir() {
+						t.Errorf("%s = %v, %v; want a directory", dir, fi, err)
+					}
+				}
+			},
+		},
+		{name: "hardlink without target", entries: []entry{hardlinkEntry("b", "a"), fileEntry("a", "x")}, wantOp: OpLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			err := ExtractToDir(fixture(t, tt.entries...), "fixture", dest, append([]Option{quiet}, tt.opts...)...)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, dest)
+		})
+	}
+}
+
+func TestExtractToDirOwnership(t *testing.T) {
+	owned := func(e entry, uid, gid int) entry {
+		e.hdr.Uid, e.hdr.Gid = uid, gid
+		return e
+	}
+	archive := fixture(t, owned(dirEntry("srv/"), 33, 33), owned(fileEntry("srv/www/index.html", "<html>"), 33, 33),
+		owned(fileEntry("srv/run", "#!/bin/sh\n"), 1000, 100), fileEntry("srv/root.conf", "root"),
+		symlinkEntry("srv/current", "run"))
+	tests := []struct {
+		name     string
+		opts     []Option
+		chownErr error
+		want     map[string][2]int
+		wantErr  error
+	}{
+		{name: "not preserved by default", want: map[string][2]int{}},
+		{name: "WithPreserveOwnership", opts: []Option{WithPreserveOwnership()}, want: map[string][2]int{
+			"srv": {33, 33}, "srv/www/index.html": {33, 33}, "srv/run": {1000, 100}, "srv/root.conf": {0, 0},
+		}},
+		{name: "stripped prefix", opts: []Option{WithPreserveOwnership(), WithStripPrefix("srv/www")}, want: map[string][2]int{
+			"index.html": {33, 33},
+		}},
+		{
+			name:     "permission errors ignored",
+			opts:     []Option{WithPreserveOwnership()},
+			chownErr: &os.PathError{Op: "chown", Path: "srv", Err: os.ErrPermission},
+			want:     map[string][2]int{"srv": {33, 33}, "srv/www/index.html": {33, 33}, "srv/run": {1000, 100}, "srv/root.conf": {0, 0}},
+		},
+		{name: "other errors returned", opts: []Option{WithPreserveOwnership()}, chownErr: errTestChown, wantErr: errTestChown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "dest")
+			m := newMemFS(root)
+			m.chownErr = tt.chownErr
+			err := ExtractToDir(archive, "fixture", root, append([]Option{WithWriterFS(m), quiet}, tt.opts...)...)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ExtractToDir() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.owners, tt.want) {
+				t.Errorf("owners = %v, want %v", m.owners, tt.want)
+			}
+		})
+	}
+
+	// On the OS filesystem chown fails for files given away unless the test
+	// runs as root, and extraction goes on regardless.
+	dest := t.TempDir()
+	if err := ExtractToDir(archive, "fixture", dest, WithPreserveOwnership()); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dest, "srv", "run")); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("srv/run = %q, %v", data, err)
+	}
+}
+
+// errTestChown is the Chown failure in TestExtractToDirOwnership.
+var errTestChown = errors.New("chown failed")
+
+func TestExtractToDirMode(t *testing.T) {
+	withMode := func(e entry, mode int64) entry {
+		e.hdr.Mode = mode
+		return e
+	}
+	archive := fixture(t, withMode(fileEntry("run.sh", "#!/bin/sh\n"), 0755), withMode(fileEntry("shared.txt", "rw for all"), 0666),
+		withMode(fileEntry("setuid", "s"), 04755), withMode(fileEntry("private.key", "k"), 0600),
+		withMode(dirEntry("locked/"), 0700), withMode(fileEntry("locked/file", "f"), 0640))
+	// Only permission bits are applied, never setuid.
+	modes := map[string]os.FileMode{"run.sh": 0755, "shared.txt": 0666, "setuid": 0755, "private.key": 0600, "locked": os.ModeDir | 0700, "locked/file": 0640}
+	tests := []struct {
+		name  string
+		opts  []Option
+		exact bool // modes match exactly rather than less the umask
+	}{
+		{name: "umask applied by default"},
+		{name: "WithPreserveMode", opts: []Option{WithPreserveMode()}, exact: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := ExtractToDir(archive, "fixture", dest, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range modes {
+				fi, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := fi.Mode()
+				// The umask can only clear bits, and never the owner's.
+				if tt.exact && got != want || !tt.exact && (got&^want != 0 || got&0700 != want&0700) {
+					t.Errorf("%s mode = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractToDirModTime(t *testing.T) {
+	older := fileEntry("dir/older.txt", "older")
+	older.hdr.ModTime = fixtureTime.Add(-24 * time.Hour)
+	accessed := fileEntry("accessed.txt", "accessed")
+	accessed.hdr.AccessTime = fixtureTime.Add(time.Hour)
+	archive := fixture(t, fileEntry("a.txt", "a"), older, accessed, hardlinkEntry("again.txt", "a.txt"))
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]time.Time // zero = the time of extraction
+	}{
+		{
+			name: "WithPreserveModTime",
+			opts: []Option{WithPreserveModTime()},
+			want: map[string]time.Time{"a.txt": fixtureTime, "dir/older.txt": older.hdr.ModTime, "accessed.txt": fixtureTime, "again.txt": fixtureTime},
+		},
+		{name: "default", want: map[string]time.Time{"a.txt": {}, "dir/older.txt": {}, "accessed.txt": {}, "again.txt": {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			start := time.Now().Add(-time.Second)
+			if err := ExtractToDir(archive, "fixture", dest, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				fi, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want.IsZero() && fi.ModTime().Before(start) || !want.IsZero() && !fi.ModTime().Equal(want) {
+					t.Errorf("%s modified %v, want %v", name, fi.ModTime(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractToDirProgress(t *testing.T) {
+	archive := fixture(t, fileEntry("a.bin", strings.Repeat("a", 40<<10)), dirEntry("d/"), fileEntry("d/b.bin", strings.Repeat("b", 24<<10)),
+		fileEntry("skip.bin", strings.Repeat("c", 1000)))
+	const total = 64 << 10
+	var calls [][2]int64
+	err := ExtractToDir(archive, "fixture", t.TempDir(), WithBufferSize(8<<10),
+		WithFilter(func(hdr *tar.Header) bool { return hdr.Name != "skip.bin" }),
+		WithProgress(func(done, all int64) { calls = append(calls, [2]int64{done, all}) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) < total/(8<<10) {
+		t.Fatalf("progress called %d times, want one per buffer", len(calls))
+	}
+	for i, c := range calls {
+		// The total counts only files that pass the filter.
+		if c[1] != total || (i > 0 && c[0] < calls[i-1][0]) {
+			t.Fatalf("progress call %d = %v, want rising counts of %d", i, c, total)
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != total {
+		t.Errorf("last progress call = %v, want %d done", last, total)
+	}
+}
@@ -0,0 +1,105 @@
+/*****************************************************************/
+/* errors.go -- Structured errors for failures while reading a   */
+/* base64 TGZ archive.                                           */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Values of ExtractError.Op.
+const (
+	OpArgument   = "argument"   // checking the caller's arguments and options
+	OpDecode     = "decode"     // base64 decoding
+	OpGzip       = "gzip"       // gzip decompression
+	OpZstd       = "zstd"       // zstd decompression
+	OpBzip2      = "bzip2"      // bzip2 decompression
+	OpXz         = "xz"         // xz decompression
+	OpDecompress = "decompress" // decompression by an autodetected or caller-supplied Decompressor
+	OpTar        = "tar"        // reading tar headers and entry bodies, including size limits
+	OpNotFound   = "notfound"   // locating the requested entry
+	OpLink       = "link"       // resolving symlinks and hardlinks to their targets
+	OpVerify     = "verify"     // checking extracted data against an expected hash
+)
+
+// ExtractError records the stage at which reading an archive failed.  Err
+// holds the cause, so errors.Is still matches ErrFileNotFound and the other
+// sentinels.  Context cancellation and errors returned by caller-supplied
+// callbacks are passed through unwrapped.
+type ExtractError struct {
+	ArchiveName string // archive name passed by the caller
+	FilePath    string // entry involved, empty if the failure is not tied to one
+	Op          string // stage that failed, one of the Op constants
+	Err         error  // underlying cause
+}
+
+// Error() -- Formats the error as "<op> [<file> in ]archive <name>: <cause>"
+func (e *ExtractError) Error() string {
+	if e.FilePath == "" {
+		return fmt.Sprintf("%s archive %q: %v", e.Op, e.ArchiveName, e.Err)
+	}
+	return fmt.Sprintf("%s %q in archive %q: %v", e.Op, e.FilePath, e.ArchiveName, e.Err)
+}
+
+// Unwrap() -- Returns the underlying cause for errors.Is and errors.As
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// notFound(string, string) -- Returns the ExtractError for a missing entry
+func notFound(archiveName, filePath string) error {
+	return &ExtractError{Op: OpNotFound, ArchiveName: archiveName, FilePath: filePath, Err: ErrFileNotFound}
+}
+
+// streamError(string, string, string, error) -- Attributes an error raised while reading an archive stream
+// Input:
+//         op           string		-- stage to report unless err came from the base64 decoder
+//         archiveName  string		-- archive name used in errors
+//         filePath     string		-- entry being read, if any
+//         err          error		-- error from the read
+// Output:
+//         error		-- err as an *ExtractError; returned unchanged if it already is one
+func streamError(op, archiveName, filePath string, err error) error {
+	var extractErr *ExtractError
+	if errors.As(err, &extractErr) {
+		return err
+	}
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		op = OpDecode
+	}
+	return &ExtractError{Op: op, ArchiveName: archiveName, FilePath: filePath, Err: err}
+}
+
+// decodeError(string, error) -- Adds archive context to base64 errors raised mid-stream
+// Input:
+//         archiveName  string		-- archive name used in errors
+//         err          error		-- error from a streaming read
+// Output:
+//         error		-- err, as an OpDecode *ExtractError if it came from the base64 decoder
+func decodeError(archiveName string, err error) error {
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return streamError(OpDecode, archiveName, "", err)
+	}
+	return err
+}
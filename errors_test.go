@@ -0,0 +1,88 @@
+/*****************************************************************/
+/* errors_test.go -- Tests the errors returned while reading     */
+/* base64 TGZ archives.                                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestArgumentErrors(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "a"))
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{"ExtractRegexp nil", func() error { _, err := ExtractRegexp(archive, "fixture", nil); return err }, nil},
+		{"ExtractGlob bad pattern", func() error { _, err := ExtractGlob(archive, "fixture", "["); return err }, path.ErrBadPattern},
+		{"ExtractRange negative offset", func() error { _, err := ExtractRange(archive, "fixture", "a.txt", -1, 1); return err }, nil},
+		{"ExtractRange negative length", func() error { _, err := ExtractRange(archive, "fixture", "a.txt", 0, -1); return err }, nil},
+		{"ExtractFileVerify bad hex", func() error { _, err := ExtractFileVerify(archive, "fixture", "a.txt", "xyz"); return err }, nil},
+		{"ExtractFileVerify short hash", func() error { _, err := ExtractFileVerify(archive, "fixture", "a.txt", "abcd"); return err }, nil},
+		{"ExtractToWriter buffer size", func() error {
+			_, err := ExtractToWriter(archive, "fixture", "a.txt", ioutil.Discard, WithBufferSize(-1))
+			return err
+		}, nil},
+		{"ExtractToDir buffer size", func() error { return ExtractToDir(archive, "fixture", t.TempDir(), WithBufferSize(-1)) }, nil},
+		{"ExtractToWriter zero buffer size", func() error {
+			_, err := ExtractToWriter(archive, "fixture", "a.txt", ioutil.Discard, WithBufferSize(0))
+			return err
+		}, nil},
+		{"ExtractToDir zero buffer size", func() error { return ExtractToDir(archive, "fixture", t.TempDir(), WithBufferSize(0)) }, nil},
+		{"Options.BufferSize zero", func() error {
+			zero := 0
+			return ExtractToDir(archive, "fixture", t.TempDir(), func(opts *Options) { opts.BufferSize = &zero })
+		}, nil},
+		{"ExtractFilePriority missing layer", func() error {
+			_, _, err := ExtractFilePriority(map[string]*string{"base": archive}, []string{"overlay", "base"}, "layers", "a.txt")
+			return err
+		}, nil},
+		{"Recompress to bzip2", func() error { _, err := Recompress(archive, CompressionGzip, CompressionBzip2); return err }, nil},
+		{"Recompress unknown format", func() error { _, err := Recompress(archive, Compression(99), CompressionGzip); return err }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkExtractError(t, tt.call(), OpArgument, tt.wantErr)
+		})
+	}
+}
+
+func TestDecompressorErrors(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "a"))
+	failing := func(io.Reader) (io.Reader, error) { return nil, errors.New("no thanks") }
+	tests := []struct {
+		name string
+		call func() error
+		op   string
+	}{
+		{"ExtractFileWith", func() error { _, err := ExtractFileWith(archive, "fixture", "a.txt", failing); return err }, OpDecompress},
+		{"ExtractFileXz", func() error { _, err := ExtractFileXz(archive, "fixture", "a.txt"); return err }, OpXz},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkExtractError(t, tt.call(), tt.op, nil)
+		})
+	}
+}
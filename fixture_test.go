@@ -0,0 +1,178 @@
+/*****************************************************************/
+/* fixture_test.go -- Builds the base64 TGZ archives the tests   */
+/* extract against.                                              */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fixtureTime is the modification time of every fixture entry.
+var fixtureTime = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// entry is one tar entry of a fixture archive.
+type entry struct {
+	hdr  tar.Header
+	body string
+}
+
+// fileEntry, dirEntry, symlinkEntry and hardlinkEntry build fixture entries.
+func fileEntry(name, body string) entry {
+	return entry{hdr: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(body)), ModTime: fixtureTime}, body: body}
+}
+
+func dirEntry(name string) entry {
+	return entry{hdr: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: fixtureTime}}
+}
+
+func symlinkEntry(name, target string) entry {
+	return entry{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777, ModTime: fixtureTime}}
+}
+
+func hardlinkEntry(name, target string) entry {
+	return entry{hdr: tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target, Mode: 0644, ModTime: fixtureTime}}
+}
+
+// tarBytes(testing.TB, ...entry) -- Writes entries as an uncompressed tar stream
+func tarBytes(t testing.TB, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("writing header %q: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("writing body %q: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes(testing.TB, []byte) -- Gzips b
+func gzipBytes(t testing.TB, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// fixture(testing.TB, ...entry) -- Builds a base64 TGZ archive of entries
+func fixture(t testing.TB, entries ...entry) *string {
+	t.Helper()
+	archive := base64.StdEncoding.EncodeToString(gzipBytes(t, tarBytes(t, entries...)))
+	return &archive
+}
+
+// sparseFragment is one stored data region of a sparse file.
+type sparseFragment struct {
+	offset int64
+	data   string
+}
+
+// gnuSparseBytes(string, int64, ...sparseFragment) -- Writes an old GNU sparse
+// entry by hand, as tar.Writer cannot.  Everything outside the fragments up to
+// size is a hole.  The result has no end-of-archive marker, so a tarBytes
+// stream can follow it.
+func gnuSparseBytes(name string, size int64, fragments ...sparseFragment) []byte {
+	hdr := make([]byte, 512)
+	octal := func(field []byte, n int64) {
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, n))
+	}
+	var stored bytes.Buffer
+	for _, f := range fragments {
+		stored.WriteString(f.data)
+	}
+	copy(hdr[0:100], name)
+	octal(hdr[100:108], 0644)
+	octal(hdr[108:116], 0)
+	octal(hdr[116:124], 0)
+	octal(hdr[124:136], int64(stored.Len()))
+	octal(hdr[136:148], fixtureTime.Unix())
+	hdr[156] = tar.TypeGNUSparse
+	copy(hdr[257:265], "ustar  \x00")
+	for i, f := range fragments { // at most four fit in the header
+		octal(hdr[386+24*i:398+24*i], f.offset)
+		octal(hdr[398+24*i:410+24*i], int64(len(f.data)))
+	}
+	octal(hdr[483:495], size)
+	copy(hdr[148:156], "        ")
+	var sum int64
+	for _, c := range hdr {
+		sum += int64(c)
+	}
+	copy(hdr[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	body := stored.Bytes()
+	if pad := len(body) % 512; pad != 0 {
+		body = append(body, make([]byte, 512-pad)...)
+	}
+	return append(hdr, body...)
+}
+
+// sampleEntries is a small tree with one entry of each kind the library
+// treats specially; sampleSize is the sum of their declared sizes.
+func sampleEntries() []entry {
+	script := fileEntry("./app-b/run.sh", "#!/bin/sh\n")
+	script.hdr.Mode = 0755
+	script.hdr.Uid, script.hdr.Gid, script.hdr.Uname, script.hdr.Gname = 1000, 100, "deploy", "staff"
+	return []entry{
+		dirEntry("app/"),
+		fileEntry("app/config.json", `{"debug":true}`),
+		fileEntry("app/readme.txt", "hello"),
+		symlinkEntry("app/latest", "config.json"),
+		hardlinkEntry("app/copy.json", "app/config.json"),
+		script,
+		fileEntry("logs/2023-01-02.txt", "jan"),
+		fileEntry("logs/2023-1-2.txt", "odd"),
+		fileEntry("logs/notes.md", "# notes"),
+		fileEntry("img/logo", "\x89PNG\r\n\x1a\n"),
+	}
+}
+
+const sampleSize = 14 + 5 + 10 + 3 + 3 + 7 + 8
+
+// files(...string) -- Builds the map ExtractAll and friends return from name, body pairs
+func files(pairs ...string) map[string][]byte {
+	m := make(map[string][]byte, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		m[pairs[i]] = []byte(pairs[i+1])
+	}
+	return m
+}
+
+// quiet keeps test output free of the library's log lines.
+var quiet = WithSilent()
@@ -0,0 +1,288 @@
+/*****************************************************************/
+/* fs.go -- Presents a base64 TGZ archive as an io/fs            */
+/* filesystem.                                                   */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExtractFromFS(fs.FS, string, string, string, ...Option) -- Extracts file from a base64 TGZ archive stored in a filesystem.
+// The archive file is streamed through the decoder, as with
+// ExtractFileReader, so an embed.FS archive is never copied into a string.
+// Input:
+//        fsys         fs.FS		-- filesystem holding the archive, e.g. an embed.FS
+//        archivePath  string		-- path of the base64 TGZ archive within fsys
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive,
+//                         or is an *fs.PathError if archivePath cannot be opened
+func ExtractFromFS(fsys fs.FS, archivePath, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	f, err := fsys.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ExtractFileReader(f, archiveName, filePath, opts...)
+}
+
+// ExtractFileFromPath(string, string, string, ...Option) -- Extracts file from a base64 TGZ archive in a file on disk.
+// The file is streamed through the decoder, as with ExtractFileReader, so
+// the base64 text is never loaded into a string.
+// Input:
+//        b64FilePath  string		-- path of the base64 TGZ file on disk
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive,
+//                         or is an *fs.PathError if b64FilePath cannot be opened
+func ExtractFileFromPath(b64FilePath, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	f, err := os.Open(b64FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ExtractFileReader(f, archiveName, filePath, opts...)
+}
+
+// archiveFS is an fs.FS over the decoded contents of an archive.
+type archiveFS struct {
+	entries map[string]*fsEntry
+}
+
+// fsEntry is a file or directory in an archiveFS.
+type fsEntry struct {
+	name     string // base name
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	children []string // full names of a directory's entries, sorted
+}
+
+// FS(*string, string, ...Option) -- Decodes a base64 TGZ archive into an fs.FS.
+// The returned filesystem also implements fs.ReadDirFS and fs.StatFS.
+// Directories implied by entry names are synthesized, leading "./" and "/"
+// are dropped from names, hardlinks share the contents of the earlier file
+// they link to, and other entries are skipped, as are hardlinks without
+// such a file.  All file contents are held in memory.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithMaxEntries, WithMaxTotalSize
+// Output:
+//         fs.FS		-- read-only filesystem over the archive
+//         err			-- Present only if error is encountered
+func FS(archive *string, archiveName string, opts ...Option) (fs.FS, error) {
+	fsys := &archiveFS{entries: map[string]*fsEntry{
+		".": {name: ".", mode: fs.ModeDir | 0755},
+	}}
+
+	cfg := optionsConfig(opts)
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		name := fsName(fileHeader.Name)
+		if name == "." || !fs.ValidPath(name) {
+			return nil
+		}
+		switch {
+		case fileHeader.Typeflag == tar.TypeDir:
+			dir := fsys.mkdirAll(name)
+			dir.mode = fs.ModeDir | fs.FileMode(fileHeader.Mode).Perm()
+			dir.modTime = fileHeader.ModTime
+		case isRegular(fileHeader):
+			if _, seen := fsys.entries[name]; seen {
+				return nil
+			}
+			data, err := readEntry(r, fileHeader, archiveName, cfg)
+			if err != nil {
+				return err
+			}
+			fsys.add(name, &fsEntry{
+				name:    path.Base(name),
+				mode:    fs.FileMode(fileHeader.Mode).Perm(),
+				modTime: fileHeader.ModTime,
+				data:    data,
+			})
+		case fileHeader.Typeflag == tar.TypeLink:
+			if _, seen := fsys.entries[name]; seen {
+				return nil
+			}
+			target, ok := fsys.entries[fsName(fileHeader.Linkname)]
+			if !ok || target.mode.IsDir() {
+				return nil
+			}
+			fsys.add(name, &fsEntry{
+				name:    path.Base(name),
+				mode:    fs.FileMode(fileHeader.Mode).Perm(),
+				modTime: fileHeader.ModTime,
+				data:    target.data,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range fsys.entries {
+		sort.Strings(entry.children)
+	}
+	return fsys, nil
+}
+
+// fsName(string) -- Normalizes a tar entry name to an fs.FS path
+func fsName(name string) string {
+	return path.Clean(strings.TrimLeft(path.Clean("/"+name), "/"))
+}
+
+// mkdirAll(string) -- Returns the directory entry for name, creating it and its parents if needed
+func (fsys *archiveFS) mkdirAll(name string) *fsEntry {
+	if entry, ok := fsys.entries[name]; ok {
+		return entry
+	}
+	dir := &fsEntry{name: path.Base(name), mode: fs.ModeDir | 0755}
+	fsys.add(name, dir)
+	return dir
+}
+
+// add(string, *fsEntry) -- Adds an entry and links it into its parent directory
+func (fsys *archiveFS) add(name string, entry *fsEntry) {
+	parent := fsys.mkdirAll(path.Dir(name))
+	fsys.entries[name] = entry
+	parent.children = append(parent.children, name)
+}
+
+// lookup(string, string) -- Finds the entry for name, or returns an *fs.PathError
+func (fsys *archiveFS) lookup(op, name string) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := fsys.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// Open(string) -- Opens the named file or directory
+func (fsys *archiveFS) Open(name string) (fs.File, error) {
+	entry, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.mode.IsDir() {
+		return &fsDir{fsys: fsys, entry: entry}, nil
+	}
+	return &fsFile{entry: entry, Reader: bytes.NewReader(entry.data)}, nil
+}
+
+// ReadDir(string) -- Lists the named directory, sorted by name
+func (fsys *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return fsys.dirEntries(entry.children), nil
+}
+
+// Stat(string) -- Describes the named file or directory
+func (fsys *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// dirEntries([]string) -- Converts full entry names to fs.DirEntry values
+func (fsys *archiveFS) dirEntries(names []string) []fs.DirEntry {
+	list := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		list[i] = fsys.entries[name]
+	}
+	return list
+}
+
+// fsEntry implements both fs.FileInfo and fs.DirEntry.
+func (e *fsEntry) Name() string               { return e.name }
+func (e *fsEntry) Size() int64                { return int64(len(e.data)) }
+func (e *fsEntry) Mode() fs.FileMode          { return e.mode }
+func (e *fsEntry) ModTime() time.Time         { return e.modTime }
+func (e *fsEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *fsEntry) Sys() interface{}           { return nil }
+func (e *fsEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *fsEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// fsFile is an open regular file.
+type fsFile struct {
+	entry *fsEntry
+	*bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is an open directory.
+type fsDir struct {
+	fsys   *archiveFS
+	entry  *fsEntry
+	offset int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *fsDir) Close() error               { return nil }
+
+// Read([]byte) -- Fails, as directories have no contents to read
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir(int) -- Returns up to n further directory entries, or all of them if n <= 0
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entry.children[d.offset:]
+	if n <= 0 {
+		d.offset += len(rest)
+		return d.fsys.dirEntries(rest), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return d.fsys.dirEntries(rest[:n]), nil
+}
@@ -0,0 +1,193 @@
+/*****************************************************************/
+/* fs_test.go -- Tests the io/fs access to base64 TGZ archives.  */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	fsys, err := FS(fixture(t, append(sampleEntries(), fileEntry("app/readme.txt", "second"))...), "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The symlink app/latest is left out.
+	if err := fstest.TestFS(fsys, "app/config.json", "app/readme.txt", "app/copy.json", "app-b/run.sh",
+		"logs/2023-01-02.txt", "logs/2023-1-2.txt", "logs/notes.md", "img/logo"); err != nil {
+		t.Fatal(err)
+	}
+
+	readTests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "file", path: "app/config.json", want: `{"debug":true}`},
+		{name: "leading ./ in the archive", path: "app-b/run.sh", want: "#!/bin/sh\n"},
+		{name: "first of duplicates", path: "app/readme.txt", want: "hello"},
+		{name: "hardlink shares data", path: "app/copy.json", want: `{"debug":true}`},
+		{name: "symlink skipped", path: "app/latest", wantErr: fs.ErrNotExist},
+		{name: "missing", path: "nope", wantErr: fs.ErrNotExist},
+		{name: "invalid path", path: "./app/readme.txt", wantErr: fs.ErrInvalid},
+	}
+	for _, tt := range readTests {
+		t.Run("ReadFile "+tt.name, func(t *testing.T) {
+			got, err := fs.ReadFile(fsys, tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	dirTests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantErr error
+	}{
+		{name: "root", path: ".", want: []string{"app", "app-b", "img", "logs"}},
+		{name: "stored directory", path: "app", want: []string{"config.json", "copy.json", "readme.txt"}},
+		{name: "synthesized directory", path: "logs", want: []string{"2023-01-02.txt", "2023-1-2.txt", "notes.md"}},
+		{name: "file", path: "img/logo", wantErr: fs.ErrInvalid},
+	}
+	for _, tt := range dirTests {
+		t.Run("ReadDir "+tt.name, func(t *testing.T) {
+			entries, err := fs.ReadDir(fsys, tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if !equalStrings(names, tt.want) {
+				t.Errorf("got %q, want %q", names, tt.want)
+			}
+		})
+	}
+
+	for name, want := range map[string]fs.FileMode{"app-b/run.sh": 0755, "app/config.json": 0644, "app": fs.ModeDir | 0755} {
+		if fi, err := fs.Stat(fsys, name); err != nil || fi.Mode() != want {
+			t.Errorf("Stat(%s) = %v, %v; want mode %v", name, fi, err, want)
+		}
+	}
+
+	corrupt := "!!!!"
+	if _, err := FS(&corrupt, "fixture", quiet); err == nil {
+		t.Error("FS() of a corrupt archive succeeded")
+	}
+}
+
+func TestExtractFromFS(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	mapFS := fstest.MapFS{
+		"assets/archive.b64": {Data: []byte(*archive)},
+		// base64(1) wraps its output at 76 columns.
+		"assets/wrapped.b64": {Data: []byte(wrap(*archive, 76, "\n") + "\n")},
+	}
+	tests := []struct {
+		name        string
+		archivePath string
+		file        string
+		want        string
+		wantErr     error
+	}{
+		{name: "file", archivePath: "assets/archive.b64", file: "logs/notes.md", want: "# notes"},
+		{name: "hardlink", archivePath: "assets/archive.b64", file: "app/copy.json", want: `{"debug":true}`},
+		{name: "line-wrapped base64", archivePath: "assets/wrapped.b64", file: "app/readme.txt", want: "hello"},
+		{name: "missing file", archivePath: "assets/archive.b64", file: "nope", wantErr: ErrFileNotFound},
+		{name: "missing archive", archivePath: "assets/nope.b64", file: "logs/notes.md", wantErr: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFromFS(mapFS, tt.archivePath, "fixture", tt.file, quiet)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExtractFromFS(mapFS, "assets", "fixture", "logs/notes.md", quiet); err == nil {
+		t.Error("ExtractFromFS() of a directory succeeded")
+	}
+}
+
+func TestExtractFileFromPath(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := write("archive.b64", *archive)
+	// base64(1) wraps its output at 76 columns and ends it with a newline.
+	wrapped := write("wrapped.b64", wrap(*archive, 76, "\n")+"\n")
+	crlf := write("crlf.b64", wrap(*archive, 64, "\r\n")+"\r\n")
+	garbage := write("garbage.b64", "!!!! not base64 ????")
+
+	tests := []struct {
+		name    string
+		b64Path string
+		file    string
+		want    string
+		wantOp  string // Op of the expected *ExtractError
+		wantErr error
+	}{
+		{name: "file", b64Path: plain, file: "./app/readme.txt", want: "hello"},
+		{name: "hardlink", b64Path: plain, file: "app/copy.json", want: `{"debug":true}`},
+		{name: "binary file", b64Path: plain, file: "img/logo", want: "\x89PNG\r\n\x1a\n"},
+		{name: "line-wrapped base64", b64Path: wrapped, file: "logs/notes.md", want: "# notes"},
+		{name: "CRLF line endings", b64Path: crlf, file: "app-b/run.sh", want: "#!/bin/sh\n"},
+		{name: "missing file", b64Path: plain, file: "nope", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "not base64", b64Path: garbage, file: "app/readme.txt", wantOp: OpDecode},
+		{name: "missing archive", b64Path: plain + ".missing", file: "app/readme.txt", wantErr: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileFromPath(tt.b64Path, "fixture", tt.file, quiet)
+			switch {
+			case tt.wantOp != "":
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+			case tt.wantErr != nil:
+				var pathErr *fs.PathError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &pathErr) || pathErr.Path != tt.b64Path {
+					t.Errorf("error = %v, want an *fs.PathError for %s wrapping %v", err, tt.b64Path, tt.wantErr)
+				}
+			case err != nil || string(got) != tt.want:
+				t.Errorf("ExtractFileFromPath() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -25,16 +25,68 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/TwiN/go-color"
 )
 
+// ErrFileNotFound is returned when the requested file is not present in the archive.
+var ErrFileNotFound = errors.New("file not found in archive")
+
+// ErrSymlinkLoop is returned when following symlinks takes more than maxSymlinkHops hops.
+var ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// maxSymlinkHops bounds symlink resolution in ExtractFileFollow, as the
+// kernel's limit does for paths on disk.
+const maxSymlinkHops = 40
+
+// ErrStopWalk can be returned by a WalkFiles callback to end the walk early without error.
+// The rest of the archive is then not decompressed, so its gzip footer is
+// not verified; ListFiles or Validate read the whole archive when that matters.
+var ErrStopWalk = errors.New("stop walk")
+
+// ErrSizeExceeded is returned when a file is larger than the configured MaxSize.
+var ErrSizeExceeded = errors.New("size limit exceeded")
+
+// ErrTooManyEntries is returned when an archive holds more entries than the configured MaxEntries.
+var ErrTooManyEntries = errors.New("too many entries")
+
+// ErrCorruptArchive is returned when malformed input makes the base64, gzip,
+// or tar decoder panic; the recovered value is included in the error message.
+// Panics raised by caller code, such as a walk callback or Logger, are not
+// recovered.
+var ErrCorruptArchive = errors.New("corrupt archive")
+
+// ErrNotUTF8 is returned under WithValidUTF8 when extracted data is not valid UTF-8.
+var ErrNotUTF8 = errors.New("file is not valid UTF-8")
+
+// DefaultLogName is the log prefix used when none is supplied.
+const DefaultLogName = "[go-selftgz]"
+
+// Decompressor wraps a base64-decoded archive stream in a decompressing
+// reader that yields the raw tar stream.  If the returned reader is an
+// io.Closer it is drained and closed once the archive has been read.
+type Decompressor func(io.Reader) (io.Reader, error)
+
 // ExtractFile(...interface{}) -- Extracts file from a base64 TGZ archive in a string.
+// filePath and entry names are compared after path.Clean, ignoring any
+// leading "./" or "/", so "./config/app.json" finds "config/app.json".
 // Input: 
 //        archivePtr  *string		-- MANDATORY
 //        archiveName  string       -- MANDATORY
@@ -42,40 +94,2201 @@ import (
 //        logName      string		-- OPTIONAL
 // Output: 
 //         []byte		-- File data
-//         err			-- Present only if error is encountered
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
 func ExtractFile(vArgs ...interface{}) ([]byte, error) {
 	archivePtr, archiveName, filePath, logName, err := extractFileParams(vArgs...)
 
 	if err != nil {
 		return nil, err
 	}
-	data, _ := base64.StdEncoding.DecodeString(*archivePtr)
-	rdata := bytes.NewReader(data)
-	rawGZ, _ := gzip.NewReader(rdata)
-	tarDat := tar.NewReader(rawGZ)
+	return ExtractWithLog(archivePtr, archiveName, filePath, logName)
+}
 
-	var fileData []byte
+// Extract(*string, string, string, ...Option) -- Extracts file from a base64 TGZ archive in a string.
+// Reading stops at the first matching entry, so the rest of the archive is
+// not decompressed and its gzip footer is only checked if filePath is missing.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, see WithLogName etc.
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func Extract(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	options := Options{ArchiveName: archiveName, FilePath: filePath}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return ExtractWithOptions(archive, options)
+}
 
-	for {
-		fileHeader, err := tarDat.Next()
-		if err == io.EOF {
-			log.Printf("%s Reached end of %s tarball read.", color.Ize(color.Cyan, logName), archiveName)
-			return nil, err
+// ExtractFileString(*string, string, string, ...Option) -- Extracts a text file from a base64 TGZ archive as a string.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithValidUTF8
+// Output:
+//         string		-- File contents
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileString(archive *string, archiveName, filePath string, opts ...Option) (string, error) {
+	fileData, err := Extract(archive, archiveName, filePath, opts...)
+	if err != nil {
+		return "", err
+	}
+	return string(fileData), nil
+}
+
+// ExtractFileWithContentType(*string, string, string, ...Option) -- Extracts file along with its MIME type.
+// The type comes from the file extension via mime.TypeByExtension, or else
+// from sniffing the first 512 bytes with http.DetectContentType.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         string		-- Content type, e.g. "application/json"
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileWithContentType(archive *string, archiveName, filePath string, opts ...Option) ([]byte, string, error) {
+	fileData, err := Extract(archive, archiveName, filePath, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if contentType == "" {
+		contentType = http.DetectContentType(fileData)
+	}
+	return fileData, contentType, nil
+}
+
+// ExtractFileOrDefault(*string, string, string, []byte, ...Option) -- Extracts an optional file, falling back to def.
+// def is returned when filePath is not in the archive.  Any other error,
+// such as a corrupt archive, is logged and def is returned as well.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        def          []byte		-- data to return if the file cannot be extracted
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithLogger
+// Output:
+//         []byte		-- File data, or def
+func ExtractFileOrDefault(archive *string, archiveName, filePath string, def []byte, opts ...Option) []byte {
+	cfg := optionsConfig(opts)
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	if err != nil {
+		if !errors.Is(err, ErrFileNotFound) {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Yellow, "WARNING -- USING DEFAULT FOR "+filePath+": "+err.Error()))
+		}
+		return def
+	}
+	return fileData
+}
+
+// MustExtractFile(*string, string, string, ...Option) -- Extract that panics on error, like regexp.MustCompile.
+// It suits package-level variables whose archive ships with the program.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithMaxSize
+// Output:
+//         []byte		-- File data
+func MustExtractFile(archive *string, archiveName, filePath string, opts ...Option) []byte {
+	fileData, err := Extract(archive, archiveName, filePath, opts...)
+	if err != nil {
+		panic(fmt.Sprintf("SelfTGZ: MustExtractFile(%q): %v", filePath, err))
+	}
+	return fileData
+}
+
+// ExtractWithLog(*string, string, string, string) -- Extract with a custom log prefix.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        logName      string		-- log prefix
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractWithLog(archive *string, archiveName, filePath, logName string) ([]byte, error) {
+	return Extract(archive, archiveName, filePath, WithLogName(logName))
+}
+
+// ExtractFileContext(context.Context, *string, string, string, ...Option) -- Extract that stops when ctx is done.
+// ctx is checked between tar entries and on every read of the decompressed
+// stream, including while the file body is read.
+// Input:
+//        ctx          context.Context	-- cancels the extraction
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; ctx.Err()
+//                         if ctx is done first
+func ExtractFileContext(ctx context.Context, archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.ctx = ctx
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	if ctxErr := contextError(ctx); err != nil && ctxErr != nil && errors.Is(err, ctxErr) {
+		return nil, ctxErr
+	}
+	return fileData, err
+}
+
+// ExtractFileTimeout(time.Duration, *string, string, string, ...Option) -- ExtractFileContext with a deadline d from now.
+// Input:
+//        d            time.Duration	-- longest the extraction may take
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered;
+//                         context.DeadlineExceeded if d elapses first
+func ExtractFileTimeout(d time.Duration, archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return ExtractFileContext(ctx, archive, archiveName, filePath, opts...)
+}
+
+// ExtractFileURLEncoding(*string, string, string, ...Option) -- Extracts file from a URL-safe base64 TGZ archive.
+// The archive may be padded (base64.URLEncoding) or unpadded
+// (base64.RawURLEncoding).  Extract also accepts both, after first trying
+// the standard alphabet.
+// Input:
+//        archive     *string		-- URL-safe base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithStrict
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileURLEncoding(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	return Extract(archive, archiveName, filePath, append([]Option{WithURLEncoding()}, opts...)...)
+}
+
+// ExtractFileTar(*string, string, string, ...Option) -- Extracts file from an uncompressed base64 tar archive.
+// Input:
+//        archive     *string		-- base64 tar archive, without gzip
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileTar(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = untar, OpTar
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// ExtractFileWith(*string, string, string, Decompressor, ...Option) -- Extracts file using a caller-supplied decompressor.
+// Input:
+//        archive     *string		-- base64 compressed tar archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        decompress   Decompressor	-- wraps the decoded stream, nil = plain tar
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileWith(archive *string, archiveName, filePath string, decompress Decompressor, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	cfg.decompress, cfg.format = callerDecompressor(decompress, cfg), OpDecompress
+	if decompress == nil {
+		cfg.decompress, cfg.format = untar, OpTar
+	}
+	fileData, _, err := extractFile(archive, archiveName, filePath, cfg)
+	return fileData, err
+}
+
+// ExtractFileReader(io.Reader, string, string, ...Option) -- Extracts file from a base64 TGZ stream.
+// The reader is decoded, decompressed, and walked incrementally, so the
+// archive is never held in memory as a whole.  A hardlink is resolved with
+// a second pass, which needs r to be an io.Seeker, as files and
+// bytes.Reader are; otherwise it fails with OpLink.
+// Input:
+//        r            io.Reader	-- base64 TGZ stream, e.g. an embed.FS file
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileReader(r io.Reader, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	fileData, _, err := extractFirst(newStreamDecoder(r), archiveName, filePath, optionsConfig(opts), func(fileHeader *tar.Header) bool {
+		return sameEntry(fileHeader.Name, filePath)
+	})
+	return fileData, err
+}
+
+// ExtractFileBytes([]byte, string, string, ...Option) -- Extracts file from a base64 TGZ archive in a byte slice.
+// Callers already holding the base64 as bytes, e.g. from embed.FS, are
+// spared converting it to a string for ExtractFile.  Unlike ExtractFile,
+// which also tries the URL-safe and unpadded alphabets, only padded
+// base64.StdEncoding is accepted.
+// Input:
+//        b64        []byte		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileBytes(b64 []byte, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	return ExtractFileReader(bytes.NewReader(b64), archiveName, filePath, opts...)
+}
+
+// ExtractFileHeader(*string, string, string, ...Option) -- Extracts file along with its tar header.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         *tar.Header		-- Header of the matching entry (mode, size, times, ...)
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileHeader(archive *string, archiveName, filePath string, opts ...Option) ([]byte, *tar.Header, error) {
+	return extractFile(archive, archiveName, filePath, optionsConfig(opts))
+}
+
+// StatFile(*string, string, string, ...Option) -- Returns a file's tar header without reading its body.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         *tar.Header		-- Header of the matching entry
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func StatFile(archive *string, archiveName, filePath string, opts ...Option) (*tar.Header, error) {
+	var header *tar.Header
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		if !sameEntry(fileHeader.Name, filePath) {
+			return nil
 		}
+		header = fileHeader
+		return ErrStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, notFound(archiveName, filePath)
+	}
+	return header, nil
+}
+
+// FileOwnership(*string, string, string, ...Option) -- Returns the owner recorded for a file in a base64 TGZ archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         int			-- Numeric user ID
+//         int			-- Numeric group ID
+//         string		-- User name, empty if not recorded
+//         string		-- Group name, empty if not recorded
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func FileOwnership(archive *string, archiveName, filePath string, opts ...Option) (uid, gid int, uname, gname string, err error) {
+	header, err := StatFile(archive, archiveName, filePath, opts...)
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+	return header.Uid, header.Gid, header.Uname, header.Gname, nil
+}
+
+// ContainsFile(*string, string, string, ...Option) -- Reports whether a base64 TGZ archive holds filePath.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         bool			-- true if an entry named filePath exists
+//         err			-- Present only if the archive cannot be read; never
+//                         set just because filePath is absent
+func ContainsFile(archive *string, archiveName, filePath string, opts ...Option) (bool, error) {
+	_, err := StatFile(archive, archiveName, filePath, opts...)
+	if errors.Is(err, ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExtractFileFollow(*string, string, string, ...Option) -- Extracts file, following symlinks within the archive.
+// A symlink's target is resolved relative to the symlink's directory and
+// looked up again, up to maxSymlinkHops times.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file or symlink within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data of the final target
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if a path is not in the archive, or
+//                         ErrSymlinkLoop if the links do not resolve
+func ExtractFileFollow(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	data, err := decodeBase64(archive, archiveName, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filePath
+	for hop := 0; hop <= maxSymlinkHops; hop++ {
+		fileData, header, err := extractFirst(bytes.NewReader(data), archiveName, name, cfg, func(fileHeader *tar.Header) bool {
+			return sameEntry(fileHeader.Name, name)
+		})
 		if err != nil {
-			log.Printf("%s %s", color.Ize(color.Cyan, logName), color.Ize(color.Red, "ERROR -- CANNOT READ "+archiveName+"!!!"))
 			return nil, err
 		}
+		if header.Typeflag != tar.TypeSymlink {
+			return fileData, nil
+		}
+		if path.IsAbs(header.Linkname) {
+			name = strings.TrimPrefix(path.Clean(header.Linkname), "/")
+		} else {
+			name = path.Join(path.Dir(header.Name), header.Linkname)
+		}
+	}
+	return nil, &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: filePath, Err: ErrSymlinkLoop}
+}
+
+// ExtractFileFold(*string, string, string, ...Option) -- Extracts file using a case-insensitive path match.
+// Names are compared with strings.EqualFold; if several entries match, the
+// first one in archive order is returned.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive, any case
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractFileFold(archive *string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	fileData, _, err := extractFirst(rdata, archiveName, filePath, cfg, func(fileHeader *tar.Header) bool {
+		return strings.EqualFold(fsName(fileHeader.Name), fsName(filePath))
+	})
+	return fileData, err
+}
+
+// ExtractByBasename(*string, string, string, ...Option) -- Extracts the first file whose base name is base, in any directory.
+// Only regular files and hardlinks match, so a directory of the same name
+// is passed over.  If several files share the base name, the first in
+// archive order is returned; ExtractAllByBasename returns them all.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        base         string		-- file name without directory, e.g. "app.json"
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         string		-- Full name of the matching entry
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if no entry has that base name
+func ExtractByBasename(archive *string, archiveName, base string, opts ...Option) ([]byte, string, error) {
+	cfg := optionsConfig(opts)
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	var matched string
+	fileData, _, err := extractFirst(rdata, archiveName, base, cfg, func(fileHeader *tar.Header) bool {
+		if (!isRegular(fileHeader) && fileHeader.Typeflag != tar.TypeLink) || path.Base(fileHeader.Name) != base {
+			return false
+		}
+		matched = fileHeader.Name
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return fileData, matched, nil
+}
+
+// ExtractAllByBasename(*string, string, string, ...Option) -- Extracts every regular file whose base name is base.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        base         string		-- file name without directory, e.g. "app.json"
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         map[string][]byte	-- File data keyed by entry name; empty if
+//                                 nothing matches
+//         err			-- Present only if error is encountered
+func ExtractAllByBasename(archive *string, archiveName, base string, opts ...Option) (map[string][]byte, error) {
+	return extractMatching(archive, archiveName, optionsConfig(opts), func(name string) bool {
+		return path.Base(name) == base
+	})
+}
+
+// ExtractIndex(*string, string, int, ...Option) -- Extracts the entry at a zero-based position in the archive.
+// Every entry counts toward the position, including directories and links.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        index        int		-- zero-based position of the entry
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- Entry data
+//         *tar.Header		-- Header of the entry
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if index is out of range
+func ExtractIndex(archive *string, archiveName string, index int, opts ...Option) ([]byte, *tar.Header, error) {
+	cfg := optionsConfig(opts)
+	var fileData []byte
+	var header *tar.Header
+	position := 0
 
-		if fileHeader.Name == filePath {
-			fileData, _ = ioutil.ReadAll(tarDat)
-			break
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		if position != index {
+			position++
+			return nil
 		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		fileData, header = data, fileHeader
+		return ErrStopWalk
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		return nil, nil, &ExtractError{Op: OpNotFound, ArchiveName: archiveName, Err: fmt.Errorf("%w: entry %d", ErrFileNotFound, index)}
+	}
+	return fileData, header, nil
+}
+
+// ExtractNested(*string, string, string, string, ...Option) -- Extracts a file from a TGZ archive stored inside a base64 TGZ archive.
+// The inner archive entry holds raw TGZ bytes, not base64 text.
+// Input:
+//        archive           *string	-- base64 TGZ archive
+//        archiveName        string	-- archive name used in logs and errors
+//        innerArchivePath   string	-- path of the inner TGZ within the archive
+//        filePath           string	-- path of the file within the inner TGZ
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if either path is missing
+func ExtractNested(archive *string, archiveName, innerArchivePath, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	inner, _, err := extractFile(archive, archiveName, innerArchivePath, cfg)
+	if err != nil {
+		return nil, err
 	}
 
+	innerName := archiveName + ":" + innerArchivePath
+	fileData, _, err := extractFirst(bytes.NewReader(inner), innerName, filePath, cfg, func(fileHeader *tar.Header) bool {
+		return sameEntry(fileHeader.Name, filePath)
+	})
 	return fileData, err
 }
 
+// ExtractFileLayered([]*string, string, string, ...Option) -- Extracts file from the last of several base64 TGZ archives that holds it.
+// Like container image layers, later archives shadow earlier ones, so the
+// archives are searched from the last; with WithFirstMatch they are
+// searched from the first instead, so the base wins.
+// Input:
+//        archives  []*string		-- base64 TGZ archives, base first
+//        archiveName  string		-- name of the layer set used in logs and errors;
+//                                 each layer is reported as archiveName[i]
+//        filePath     string		-- path of the file within the archives
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithFirstMatch
+// Output:
+//         []byte		-- File data from the selected archive
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if no archive holds filePath
+func ExtractFileLayered(archives []*string, archiveName, filePath string, opts ...Option) ([]byte, error) {
+	cfg := optionsConfig(opts)
+	for n := range archives {
+		i := len(archives) - 1 - n
+		if cfg.firstMatch {
+			i = n
+		}
+		fileData, _, err := extractFile(archives[i], fmt.Sprintf("%s[%d]", archiveName, i), filePath, cfg)
+		if errors.Is(err, ErrFileNotFound) {
+			continue
+		}
+		return fileData, err
+	}
+	return nil, notFound(archiveName, filePath)
+}
+
+// ExtractFilePriority(map[string]*string, []string, string, string, ...Option) -- Extracts file from the first named layer, in priority order, that holds it.
+// Input:
+//        layers       map[string]*string	-- base64 TGZ archives keyed by layer name
+//        order      []string		-- layer names, highest priority first
+//        archiveName  string		-- name of the layer set used in logs and errors;
+//                                 each layer is reported as archiveName[name]
+//        filePath     string		-- path of the file within the archives
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data from the selected layer
+//         string		-- Name of the layer the file came from
+//         err			-- Present only if error is encountered, including a
+//                         name in order missing from layers; wraps
+//                         ErrFileNotFound if no layer holds filePath
+func ExtractFilePriority(layers map[string]*string, order []string, archiveName, filePath string, opts ...Option) ([]byte, string, error) {
+	cfg := optionsConfig(opts)
+	for _, layer := range order {
+		archive, ok := layers[layer]
+		if !ok {
+			return nil, "", &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: fmt.Errorf("no layer named %q", layer)}
+		}
+		fileData, _, err := extractFile(archive, fmt.Sprintf("%s[%s]", archiveName, layer), filePath, cfg)
+		if errors.Is(err, ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return fileData, layer, nil
+	}
+	return nil, "", notFound(archiveName, filePath)
+}
+
+// extractFile(*string, string, string, *config) -- Extracts the entry named filePath from a base64 archive
+// Input:
+//         archive     *string		-- base64 archive
+//         archiveName  string		-- archive name used in logs and errors
+//         filePath     string		-- path of the file within the archive
+//         cfg         *config		-- decoding settings
+// Output:
+//         []byte		-- File data
+//         *tar.Header		-- Header of the matching entry
+//         err          error		-- wraps ErrFileNotFound if filePath is not in the archive
+func extractFile(archive *string, archiveName, filePath string, cfg *config) ([]byte, *tar.Header, error) {
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return extractFirst(rdata, archiveName, filePath, cfg, func(fileHeader *tar.Header) bool {
+		return sameEntry(fileHeader.Name, filePath)
+	})
+}
+
+// extractFirst(io.Reader, string, string, *config, func) -- Extracts the first entry whose header satisfies match
+// A matching hardlink is resolved to the earlier entry it links to; this
+// needs a second walk, so rdata must then be an io.Seeker.
+// Input:
+//         rdata        io.Reader	-- base64-decoded archive stream
+//         archiveName  string		-- archive name used in logs and errors
+//         filePath     string		-- requested path, used in logs and errors
+//         cfg         *config		-- decoding settings
+//         match        func		-- reports whether an entry is the requested file
+// Output:
+//         []byte		-- File data
+//         *tar.Header		-- Header of the entry the data came from
+//         err          error		-- wraps ErrFileNotFound if nothing matches
+func extractFirst(rdata io.Reader, archiveName, filePath string, cfg *config, match func(*tar.Header) bool) ([]byte, *tar.Header, error) {
+	var fileData []byte
+	var header *tar.Header
+	seen := make(map[string]bool)
+
+	err := walkStream(rdata, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		if !match(fileHeader) {
+			seen[fsName(fileHeader.Name)] = true
+			return nil
+		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		fileData, header = data, fileHeader
+		return ErrStopWalk
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		cfg.debugf("%s Reached end of %s tarball read.", cfg.paint(color.Cyan, cfg.logName), archiveName)
+		return nil, nil, notFound(archiveName, filePath)
+	}
+
+	if header.Typeflag == tar.TypeLink {
+		target := header.Linkname
+		if !seen[fsName(target)] {
+			return nil, nil, &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: header.Name, Err: fmt.Errorf("hardlink points to %q, which does not precede it", target)}
+		}
+		seeker, ok := rdata.(io.Seeker)
+		if !ok {
+			return nil, nil, &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: header.Name, Err: errors.New("hardlink cannot be resolved in a streamed archive")}
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return extractFirst(rdata, archiveName, target, cfg, func(fileHeader *tar.Header) bool {
+			return sameEntry(fileHeader.Name, target)
+		})
+	}
+	return fileData, header, nil
+}
+
+// ListFiles(*string, string, ...Option) -- Lists every entry name in a base64 TGZ archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithMaxTotalSize
+// Output:
+//         []string		-- Entry names in archive order
+//         err			-- Present only if error is encountered
+func ListFiles(archive *string, archiveName string, opts ...Option) ([]string, error) {
+	var names []string
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		names = append(names, fileHeader.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ListEntries(*string, string, ...Option) -- Returns the header of every entry in a base64 TGZ archive.
+// Directories, symlinks, and all other entry types are included, in
+// archive order, so the full tree can be reconstructed.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         []tar.Header		-- Entry headers in archive order
+//         err			-- Present only if error is encountered
+func ListEntries(archive *string, archiveName string, opts ...Option) ([]tar.Header, error) {
+	var headers []tar.Header
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		headers = append(headers, *fileHeader)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// Entry describes one archive entry without tar-specific detail.
+type Entry struct {
+	Name     string      // entry name as stored, e.g. "dir/" or "dir/file"
+	Size     int64       // body size in bytes, 0 for directories and links
+	Mode     fs.FileMode // permission and type bits, e.g. fs.ModeDir|0755
+	ModTime  time.Time   // modification time
+	IsDir    bool        // true for directories
+	Linkname string      // target of a symlink or hardlink, otherwise empty
+}
+
+// Entries(*string, string, ...Option) -- Describes every entry in a base64 TGZ archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         []Entry		-- One Entry per tar entry, in archive order
+//         err			-- Present only if error is encountered
+func Entries(archive *string, archiveName string, opts ...Option) ([]Entry, error) {
+	var entries []Entry
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		info := fileHeader.FileInfo()
+		entries = append(entries, Entry{
+			Name:     fileHeader.Name,
+			Size:     fileHeader.Size,
+			Mode:     info.Mode(),
+			ModTime:  fileHeader.ModTime,
+			IsDir:    info.IsDir(),
+			Linkname: fileHeader.Linkname,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadDir(*string, string, string, ...Option) -- Lists the immediate children of a directory in a base64 TGZ archive.
+// Like fs.ReadDir, only entries directly inside dir are returned, sorted by
+// name.  Subdirectories with no header of their own are synthesized from the
+// paths beneath them as TypeDir entries with mode 0755.  Names are cleaned as
+// in FS: "./" and leading slashes are dropped, and directories lose their
+// trailing slash, so Name is always the child's full path within the archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        dir          string		-- directory to list, "" or "." for the top level
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         []tar.Header		-- Headers of the children of dir
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if dir is not in the archive
+func ReadDir(archive *string, archiveName, dir string, opts ...Option) ([]tar.Header, error) {
+	dir = fsName(dir)
+	children := make(map[string]tar.Header)
+	synthesized := make(map[string]bool)
+	dirSeen := dir == "."
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		name := fsName(fileHeader.Name)
+		if name == dir {
+			dirSeen = dirSeen || fileHeader.Typeflag == tar.TypeDir
+			return nil
+		}
+		rel := name
+		if dir != "." {
+			if !strings.HasPrefix(name, dir+"/") {
+				return nil
+			}
+			rel = name[len(dir)+1:]
+		}
+		dirSeen = true
+
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			child := path.Join(dir, rel[:i])
+			if _, ok := children[child]; !ok {
+				children[child] = tar.Header{Name: child, Typeflag: tar.TypeDir, Mode: 0755}
+				synthesized[child] = true
+			}
+			return nil
+		}
+		// An explicit header replaces a synthesized one, but not an earlier explicit one.
+		if _, ok := children[name]; !ok || synthesized[name] {
+			header := *fileHeader
+			header.Name = name
+			children[name] = header
+			delete(synthesized, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !dirSeen {
+		return nil, notFound(archiveName, dir)
+	}
+
+	headers := make([]tar.Header, 0, len(children))
+	for _, header := range children {
+		headers = append(headers, header)
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers, nil
+}
+
+// CountFiles(*string, string, ...Option) -- Counts the regular files in a base64 TGZ archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         int			-- Number of regular-file entries; directories and
+//                         other entry types are not counted
+//         err			-- Present only if error is encountered
+func CountFiles(archive *string, archiveName string, opts ...Option) (int, error) {
+	count := 0
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		if isRegular(fileHeader) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FindDuplicates(*string, string, ...Option) -- Reports entry names that occur more than once in a base64 TGZ archive.
+// Lookups by name take the first of several entries, so duplicates are
+// usually a packing mistake.  Names are cleaned as in FS first, so
+// "./a.txt" and "a.txt" count as the same name.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         map[string]int	-- Occurrence count of each duplicated name, keyed by
+//                                 cleaned name; empty if all names are unique
+//         err			-- Present only if error is encountered
+func FindDuplicates(archive *string, archiveName string, opts ...Option) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		counts[fsName(fileHeader.Name)]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, count := range counts {
+		if count < 2 {
+			delete(counts, name)
+		}
+	}
+	return counts, nil
+}
+
+// DecompressedSize(*string, string, ...Option) -- Sums the sizes declared by every entry header in a base64 TGZ archive.
+// No entry bodies are read, though the stream is still decompressed to reach
+// each header.  Tar framing and padding are not included.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         int64		-- Total of hdr.Size over all entries
+//         err			-- Present only if error is encountered
+func DecompressedSize(archive *string, archiveName string, opts ...Option) (int64, error) {
+	var total int64
+
+	err := walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		total += fileHeader.Size
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// CompressedSize(*string, ...Option) -- Returns the number of bytes a base64 archive decodes to.
+// This is the size of the compressed archive; with DecompressedSize it
+// gives the compression ratio.  The decoded bytes are counted as they
+// stream past and are not kept.
+// Input:
+//        archive     *string		-- base64 archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         int			-- Length of the decoded, still compressed archive
+//         err			-- Present only if error is encountered
+func CompressedSize(archive *string, opts ...Option) (int, error) {
+	rdata, err := decodeArchive(archive, "", optionsConfig(opts))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(ioutil.Discard, rdata)
+	if err != nil {
+		return 0, decodeError("", err)
+	}
+	return int(n), nil
+}
+
+// Summary(*string, string, ...Option) -- Counts the entries of a base64 TGZ archive and sums their sizes in one walk.
+// Entries of every type are counted; the size is the same as DecompressedSize.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         int			-- Number of entries
+//         int64		-- Total of hdr.Size over all entries
+//         err			-- Present only if error is encountered
+func Summary(archive *string, archiveName string, opts ...Option) (entries int, totalSize int64, err error) {
+	err = walkArchive(archive, archiveName, optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		entries++
+		totalSize += fileHeader.Size
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return entries, totalSize, nil
+}
+
+// GzipMetadata(*string, ...Option) -- Returns the gzip header of a base64 TGZ archive.
+// Only the start of the stream is decompressed.  For a multistream archive
+// the header is that of the first member.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         gzip.Header		-- Name, Comment, ModTime, and the other header fields
+//         err			-- Present only if error is encountered
+func GzipMetadata(archive *string, opts ...Option) (header gzip.Header, err error) {
+	const archiveName = "archive"
+	cfg := optionsConfig(opts)
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return gzip.Header{}, err
+	}
+	raw, err := openStream(rdata, archiveName, cfg)
+	if err != nil {
+		return gzip.Header{}, err
+	}
+	defer closeStream(raw, nil)
+	return raw.(*pooledGzip).Header, nil
+}
+
+// WalkFiles(*string, string, func, ...Option) -- Calls fn for every entry in a base64 TGZ archive.
+// Entries of every type are visited in archive order.  r is only valid
+// until fn returns.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        fn           func		-- called with each header and a reader over its
+//                                 body; return ErrStopWalk to stop early,
+//                                 leaving the gzip footer unverified
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         err			-- Present only if error is encountered, including any
+//                         error other than ErrStopWalk returned by fn
+func WalkFiles(archive *string, archiveName string, fn func(hdr *tar.Header, r io.Reader) error, opts ...Option) error {
+	return walkArchive(archive, archiveName, optionsConfig(opts), fn)
+}
+
+// ExtractAll(*string, string, ...Option) -- Extracts every regular file from a base64 TGZ archive.
+// Hardlinks are included and share the data of the regular file they link to.
+// The archive is decoded once, but every file body is held in memory at the
+// same time, so the result is roughly as large as the uncompressed archive.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithMaxSize, WithMaxTotalSize
+// Output:
+//         map[string][]byte	-- File data keyed by entry name
+//         err			-- Present only if error is encountered
+func ExtractAll(archive *string, archiveName string, opts ...Option) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	cfg := optionsConfig(opts)
+	links := newHardlinks()
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		links.note(fileHeader)
+		if fileHeader.Typeflag == tar.TypeLink {
+			links.want(fileHeader.Name, fileHeader)
+			return nil
+		}
+		if !isRegular(fileHeader) {
+			return nil
+		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		files[fileHeader.Name] = data
+		links.keep(fileHeader, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := links.resolve(archive, archiveName, cfg, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ExtractFiles(*string, string, []string, ...Option) -- Extracts several files in a single archive walk.
+// Paths are matched as by ExtractFile, so "config/app.json" finds an entry
+// stored as "./config/app.json".  The walk ends at the last requested file,
+// so, as with ExtractFile, the rest of the archive is not decompressed and a
+// bad gzip footer goes unnoticed.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        paths      []string		-- paths of the files within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         map[string][]byte	-- File data keyed by requested path
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound and lists every missing path if
+//                         any of paths is not in the archive
+func ExtractFiles(archive *string, archiveName string, paths []string, opts ...Option) (map[string][]byte, error) {
+	wanted := make(map[string][]string, len(paths)) // fsName -> requested paths
+	for _, p := range paths {
+		name := fsName(p)
+		wanted[name] = append(wanted[name], p)
+	}
+	files := make(map[string][]byte, len(paths))
+	cfg := optionsConfig(opts)
+	links := newHardlinks()
+	found := make(map[string]bool, len(wanted))
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		links.note(fileHeader)
+		name := fsName(fileHeader.Name)
+		if wanted[name] == nil || found[name] {
+			return nil
+		}
+		found[name] = true
+		if fileHeader.Typeflag == tar.TypeLink {
+			for _, p := range wanted[name] {
+				links.want(p, fileHeader)
+			}
+		} else {
+			data, err := readEntry(r, fileHeader, archiveName, cfg)
+			if err != nil {
+				return err
+			}
+			for _, p := range wanted[name] {
+				files[p] = data
+			}
+			links.keep(fileHeader, data)
+		}
+		if len(found) == len(wanted) {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := links.resolve(archive, archiveName, cfg, files); err != nil {
+		return nil, err
+	}
+
+	if len(found) != len(wanted) {
+		var missing []string
+		for _, p := range paths {
+			if _, ok := files[p]; !ok {
+				missing = append(missing, p)
+			}
+		}
+		return nil, &ExtractError{Op: OpNotFound, ArchiveName: archiveName, Err: fmt.Errorf("%w: %q", ErrFileNotFound, missing)}
+	}
+	return files, nil
+}
+
+// ExtractGlob(*string, string, string, ...Option) -- Extracts every regular file matching a glob pattern.
+// Names and pattern are cleaned as in FS before matching, so "logs/*.txt"
+// also selects "./logs/a.txt".
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        pattern      string		-- pattern with path.Match semantics
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         map[string][]byte	-- File data keyed by entry name; empty if
+//                                 nothing matches
+//         err			-- Present only if error is encountered, including
+//                         one with OpArgument wrapping path.ErrBadPattern
+//                         for a malformed pattern
+func ExtractGlob(archive *string, archiveName, pattern string, opts ...Option) (map[string][]byte, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: fmt.Errorf("pattern %q: %w", pattern, err)}
+	}
+	pattern = fsName(pattern)
+	return extractMatching(archive, archiveName, optionsConfig(opts), func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	})
+}
+
+// ExtractRegexp(*string, string, *regexp.Regexp, ...Option) -- Extracts every regular file matching a regular expression.
+// The expression is applied to names cleaned as in FS, without "./".
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        re          *regexp.Regexp	-- compiled expression, applied with re.MatchString
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         map[string][]byte	-- File data keyed by entry name; empty if
+//                                 nothing matches
+//         err			-- Present only if error is encountered
+func ExtractRegexp(archive *string, archiveName string, re *regexp.Regexp, opts ...Option) (map[string][]byte, error) {
+	if re == nil {
+		return nil, &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: errors.New("nil regular expression")}
+	}
+	return extractMatching(archive, archiveName, optionsConfig(opts), re.MatchString)
+}
+
+// ExtractPrefix(*string, string, string, ...Option) -- Extracts every regular file under a directory prefix.
+// Names and prefix are cleaned as in FS before comparing, and prefix matches
+// whole path elements, so "app-a/" selects "app-a/bin/run" but not
+// "app-ab/run".  Keys keep the full entry name unless WithStripPrefix is
+// given, which trims them as ExtractToDir does.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        prefix       string		-- directory to extract, e.g. "app-a/"
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithStripPrefix, WithMaxSize
+// Output:
+//         map[string][]byte	-- File data keyed by entry name; empty if
+//                                 nothing is under prefix
+//         err			-- Present only if error is encountered
+func ExtractPrefix(archive *string, archiveName, prefix string, opts ...Option) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	cfg := optionsConfig(opts)
+
+	links := newHardlinks()
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		links.note(fileHeader)
+		if !isRegular(fileHeader) && fileHeader.Typeflag != tar.TypeLink {
+			return nil
+		}
+		if _, ok := stripEntryPrefix(fsName(fileHeader.Name), prefix); !ok {
+			return nil
+		}
+		name := fileHeader.Name
+		if cfg.stripPrefix != "" {
+			stripped, ok := stripEntryPrefix(fsName(name), cfg.stripPrefix)
+			if !ok || stripped == "" {
+				return nil
+			}
+			name = stripped
+		}
+		if fileHeader.Typeflag == tar.TypeLink {
+			links.want(name, fileHeader)
+			return nil
+		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		links.keep(fileHeader, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := links.resolve(archive, archiveName, cfg, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// extractMatching(*string, string, *config, func) -- Extracts every regular file whose name satisfies match
+// Matching hardlinks are included with the data of the file they link to.
+// Input:
+//         archive     *string		-- base64 TGZ archive
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+//         match        func		-- reports whether an entry name, cleaned by fsName, is wanted
+// Output:
+//         map[string][]byte		-- File data keyed by entry name
+//         err          error		-- set if the archive cannot be read
+func extractMatching(archive *string, archiveName string, cfg *config, match func(string) bool) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	links := newHardlinks()
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		links.note(fileHeader)
+		if !match(fsName(fileHeader.Name)) {
+			return nil
+		}
+		if fileHeader.Typeflag == tar.TypeLink {
+			links.want(fileHeader.Name, fileHeader)
+			return nil
+		}
+		if !isRegular(fileHeader) {
+			return nil
+		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		files[fileHeader.Name] = data
+		links.keep(fileHeader, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := links.resolve(archive, archiveName, cfg, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// hardlinks fills in the hardlinks selected by a walk that extracts several
+// files.  The walk notes every hardlink it passes and keeps the data of
+// every regular file it reads; resolve then gives each selected hardlink
+// the data of the regular file at the end of its chain, walking the
+// archive a second time only for files the first walk did not read.
+type hardlinks struct {
+	targets map[string]string   // fsName of each hardlink -> fsName of its Linkname
+	read    map[string][]byte   // fsName -> data of each regular file read
+	wanted  map[string][]string // fsName of each selected hardlink -> result keys
+}
+
+// newHardlinks() -- Returns an empty hardlinks
+func newHardlinks() *hardlinks {
+	return &hardlinks{targets: make(map[string]string), read: make(map[string][]byte), wanted: make(map[string][]string)}
+}
+
+// note(*tar.Header) -- Records the target of a hardlink entry; other entries are ignored
+func (h *hardlinks) note(fileHeader *tar.Header) {
+	if fileHeader.Typeflag != tar.TypeLink {
+		return
+	}
+	name := fsName(fileHeader.Name)
+	if _, seen := h.targets[name]; !seen {
+		h.targets[name] = fsName(fileHeader.Linkname)
+	}
+}
+
+// keep(*tar.Header, []byte) -- Records the data read for a regular file
+func (h *hardlinks) keep(fileHeader *tar.Header, data []byte) {
+	name := fsName(fileHeader.Name)
+	if _, seen := h.read[name]; !seen {
+		h.read[name] = data
+	}
+}
+
+// want(string, *tar.Header) -- Selects a hardlink entry, to be stored under key
+func (h *hardlinks) want(key string, fileHeader *tar.Header) {
+	name := fsName(fileHeader.Name)
+	h.wanted[name] = append(h.wanted[name], key)
+}
+
+// resolve(*string, string, *config, map[string][]byte) -- Stores the data of every selected hardlink in files
+// Input:
+//         archive     *string		-- base64 TGZ archive, walked again if needed
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+//         files        map[string][]byte	-- extraction result to fill in
+// Output:
+//         err          error		-- set if a hardlink does not lead to a regular
+//                                     file, or the archive cannot be read
+func (h *hardlinks) resolve(archive *string, archiveName string, cfg *config, files map[string][]byte) error {
+	if len(h.wanted) == 0 {
+		return nil
+	}
+	// Follow chains of hardlinks to the regular file at the end.
+	links := make(map[string]string, len(h.wanted))
+	missing := make(map[string]bool)
+	for name := range h.wanted {
+		target := h.targets[name]
+		for hop := 0; hop < len(h.targets); hop++ {
+			next, ok := h.targets[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+		links[name] = target
+		if _, ok := h.read[target]; !ok {
+			missing[target] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+			name := fsName(fileHeader.Name)
+			if !isRegular(fileHeader) || !missing[name] {
+				return nil
+			}
+			data, err := readEntry(r, fileHeader, archiveName, cfg)
+			if err != nil {
+				return err
+			}
+			h.read[name] = data
+			delete(missing, name)
+			if len(missing) == 0 {
+				return ErrStopWalk
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(links))
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, ok := h.read[links[name]]
+		if !ok {
+			return &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: name, Err: fmt.Errorf("hardlink points to %q, which is not a regular file in the archive", links[name])}
+		}
+		for _, key := range h.wanted[name] {
+			files[key] = data
+		}
+	}
+	return nil
+}
+
+// config carries the settings shared by the internal archive walkers.
+type config struct {
+	logName    string
+	encodings  []*base64.Encoding
+	decompress Decompressor
+	format     string
+	maxSize    int64
+	maxTotal   int64
+	maxEntries int
+	validUTF8  bool
+	ctx        context.Context
+	logger     Logger
+	quiet      bool
+	debug      bool
+	color      bool
+	callerCode bool // set while a caller-supplied Decompressor runs; see panicError
+
+	// gzip settings
+	singleStream bool
+
+	// base64 input settings
+	strict           bool
+	ignoreWhitespace bool
+
+	// ExtractToDir settings
+	stripPrefix     string
+	filter          func(*tar.Header) bool
+	preserveModTime bool
+	preserveMode    bool
+	preserveOwner   bool
+	writerFS        WriterFS
+
+	// ExtractToDir and ExtractToWriter settings
+	progress   func(bytesDone, bytesTotal int64)
+	bufferSize *int // nil = io.Copy's default
+
+	// ExtractFileLayered settings
+	firstMatch bool
+}
+
+// defaultConfig() -- Returns the settings used by the plain TGZ functions
+func defaultConfig() *config {
+	return &config{
+		logName:          DefaultLogName,
+		encodings:        defaultEncodings,
+		decompress:       gunzip,
+		format:           OpGzip,
+		logger:           stdLogger{},
+		color:            isTerminal(log.Writer()),
+		ignoreWhitespace: true,
+	}
+}
+
+// walkArchive(*string, string, *config, func) -- Calls visit for every entry in a base64 archive
+// Input:
+//         archive     *string		-- base64 archive
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+//         visit        func		-- called with each header and a reader over its body;
+//                                 return ErrStopWalk to stop early
+// Output:
+//         err          error		-- first error from decoding, reading, or visit
+func walkArchive(archive *string, archiveName string, cfg *config, visit func(*tar.Header, io.Reader) error) error {
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return err
+	}
+	return walkStream(rdata, archiveName, cfg, visit)
+}
+
+// walkStream(io.Reader, string, *config, func) -- Calls visit for every entry in a compressed tar stream
+// Input:
+//         rdata        io.Reader	-- base64-decoded archive stream
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+//         visit        func		-- called with each header and a reader over its body;
+//                                 return ErrStopWalk to stop early
+// Output:
+//         err          error		-- first error from decoding, reading, or visit
+func walkStream(rdata io.Reader, archiveName string, cfg *config, visit func(*tar.Header, io.Reader) error) (err error) {
+	raw, err := openStream(rdata, archiveName, cfg)
+	if err != nil {
+		return err
+	}
+	var tarStream io.Reader = raw
+	if cfg.maxTotal > 0 {
+		tarStream = &totalLimitReader{r: raw, limit: cfg.maxTotal, archiveName: archiveName}
+	}
+	if cfg.ctx != nil {
+		tarStream = &ctxReader{ctx: cfg.ctx, r: tarStream}
+	}
+	reachedEnd := false
+	defer func() {
+		// Only a walk that read every entry is worth verifying.  A failed one
+		// could take a while to drain, one stopped early may have most of the
+		// archive left, and one unwinding a panic from visit should not
+		// decode further.  Trailing data counts against cfg.maxTotal like
+		// the entries do.
+		var drain io.Reader
+		if err == nil && reachedEnd {
+			drain = tarStream
+		}
+		cerr := guard(archiveName, cfg, func() error { return closeStream(raw, drain) })
+		if cerr != nil && err == nil {
+			err = streamError(cfg.format, archiveName, "", cerr)
+		}
+	}()
+	tarDat := tar.NewReader(tarStream)
+	body := &guardedReader{r: tarDat, archiveName: archiveName, cfg: cfg}
+
+	for entries := 1; ; entries++ {
+		if cfg.ctx != nil {
+			if err := contextError(cfg.ctx); err != nil {
+				return err
+			}
+		}
+		fileHeader, err := nextHeader(tarDat, archiveName, cfg)
+		if err == io.EOF {
+			reachedEnd = true
+			return nil
+		}
+		if err != nil {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT READ "+archiveName+"!!!"))
+			return streamError(OpTar, archiveName, "", err)
+		}
+		if cfg.maxEntries > 0 && entries > cfg.maxEntries {
+			return &ExtractError{Op: OpTar, ArchiveName: archiveName, Err: fmt.Errorf("%w: over %d entries", ErrTooManyEntries, cfg.maxEntries)}
+		}
+		if err := visit(fileHeader, body); err != nil {
+			if err == ErrStopWalk {
+				return nil
+			}
+			return decodeError(archiveName, err)
+		}
+	}
+}
+
+// nextHeader(*tar.Reader, string, *config) -- Advances tarDat, returning a panic in the decoders as ErrCorruptArchive
+// The error is not logged, as callers log every failed Next.
+func nextHeader(tarDat *tar.Reader, archiveName string, cfg *config) (fileHeader *tar.Header, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fileHeader, err = nil, panicError(archiveName, cfg, r)
+		}
+	}()
+	return tarDat.Next()
+}
+
+// guardedReader reads an entry body, returning a panic in the decoders as
+// ErrCorruptArchive.  Code the caller runs on the bytes read is not guarded.
+type guardedReader struct {
+	r           io.Reader
+	archiveName string
+	cfg         *config
+}
+
+// Read([]byte) -- Reads from the underlying reader
+func (g *guardedReader) Read(p []byte) (n int, err error) {
+	defer recoverCorrupt(g.archiveName, g.cfg, &err)
+	return g.r.Read(p)
+}
+
+// guard(string, *config, func() error) -- Runs call, returning a panic in the decoders as ErrCorruptArchive
+// call must only reach library and SelfTGZ code, or caller code marked by
+// cfg.callerCode, so that no caller panic is mistaken for corrupt input.
+func guard(archiveName string, cfg *config, call func() error) (err error) {
+	defer recoverCorrupt(archiveName, cfg, &err)
+	return call()
+}
+
+// recoverCorrupt(string, *config, *error) -- Deferred to turn a panic while decoding an archive into ErrCorruptArchive
+func recoverCorrupt(archiveName string, cfg *config, err *error) {
+	if r := recover(); r != nil {
+		*err = corruptError(archiveName, cfg, r)
+	}
+}
+
+// corruptError(string, *config, interface{}) -- Logs a recovered panic and returns it as ErrCorruptArchive
+func corruptError(archiveName string, cfg *config, r interface{}) error {
+	err := panicError(archiveName, cfg, r)
+	cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT READ "+archiveName+"!!!"))
+	return err
+}
+
+// panicError(string, *config, interface{}) -- Returns a recovered panic as ErrCorruptArchive
+// A panic raised while cfg.callerCode is set came from the caller's own
+// Decompressor and is raised again rather than blamed on the archive.
+func panicError(archiveName string, cfg *config, r interface{}) error {
+	if cfg.callerCode {
+		panic(r)
+	}
+	return &ExtractError{Op: OpTar, ArchiveName: archiveName, Err: fmt.Errorf("%w: panic: %v", ErrCorruptArchive, r)}
+}
+
+// callerDecompressor(Decompressor, *config) -- Wraps a caller-supplied Decompressor so its panics are not recovered
+// Output:
+//         Decompressor		-- decompress, setting cfg.callerCode while it or
+//                         the reader it returns runs; nil if decompress is nil
+func callerDecompressor(decompress Decompressor, cfg *config) Decompressor {
+	if decompress == nil {
+		return nil
+	}
+	return func(r io.Reader) (io.Reader, error) {
+		cfg.callerCode = true
+		raw, err := decompress(r)
+		cfg.callerCode = false
+		if err != nil || raw == nil {
+			return raw, err
+		}
+		return &callerReader{r: raw, cfg: cfg}, nil
+	}
+}
+
+// callerReader is a reader returned by a caller-supplied Decompressor.
+// cfg.callerCode is set during each call into it; it stays set if the call
+// panics, so the panic passes through the guards around the tar reader.
+type callerReader struct {
+	r   io.Reader
+	cfg *config
+}
+
+// Read([]byte) -- Reads from the caller's reader
+func (c *callerReader) Read(p []byte) (int, error) {
+	c.cfg.callerCode = true
+	n, err := c.r.Read(p)
+	c.cfg.callerCode = false
+	return n, err
+}
+
+// Close() -- Closes the caller's reader if it is an io.Closer
+func (c *callerReader) Close() error {
+	closer, ok := c.r.(io.Closer)
+	if !ok {
+		return nil
+	}
+	c.cfg.callerCode = true
+	err := closer.Close()
+	c.cfg.callerCode = false
+	return err
+}
+
+// readEntry(io.Reader, *tar.Header, string, *config) -- Reads an entry body, enforcing cfg.maxSize
+// Input:
+//         r            io.Reader	-- entry body
+//         fileHeader  *tar.Header	-- entry header
+//         archiveName  string		-- archive name used in errors
+//         cfg         *config		-- decoding settings
+// Output:
+//         []byte		-- File data
+//         err          error		-- wraps ErrSizeExceeded if the body is over the limit
+func readEntry(r io.Reader, fileHeader *tar.Header, archiveName string, cfg *config) ([]byte, error) {
+	if cfg.maxSize <= 0 {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, streamError(OpTar, archiveName, fileHeader.Name, err)
+		}
+		return checkUTF8(data, fileHeader, archiveName, cfg)
+	}
+	if fileHeader.Size > cfg.maxSize {
+		return nil, &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("%w: declares %d bytes, limit is %d", ErrSizeExceeded, fileHeader.Size, cfg.maxSize)}
+	}
+	// Read one byte past the limit so an oversized body is detected rather than truncated.
+	data, err := ioutil.ReadAll(io.LimitReader(r, cfg.maxSize+1))
+	if err != nil {
+		return nil, streamError(OpTar, archiveName, fileHeader.Name, err)
+	}
+	if int64(len(data)) > cfg.maxSize {
+		return nil, &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("%w: over %d bytes", ErrSizeExceeded, cfg.maxSize)}
+	}
+	return checkUTF8(data, fileHeader, archiveName, cfg)
+}
+
+// checkUTF8([]byte, *tar.Header, string, *config) -- Applies cfg.validUTF8 to extracted data
+// Output:
+//         []byte		-- data, unchanged
+//         err          error		-- wraps ErrNotUTF8 if validation is on and data is not UTF-8
+func checkUTF8(data []byte, fileHeader *tar.Header, archiveName string, cfg *config) ([]byte, error) {
+	if cfg.validUTF8 && !utf8.Valid(data) {
+		return nil, &ExtractError{Op: OpVerify, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: ErrNotUTF8}
+	}
+	return data, nil
+}
+
+// copyEntry(io.Writer, io.Reader, *tar.Header, string, *config) -- Copies an entry body to w, enforcing cfg.maxSize
+// Input:
+//         w            io.Writer	-- destination
+//         r            io.Reader	-- entry body
+//         fileHeader  *tar.Header	-- entry header
+//         archiveName  string		-- archive name used in errors
+//         cfg         *config		-- decoding settings
+// Output:
+//         int64		-- bytes written to w
+//         err          error		-- wraps ErrSizeExceeded if the body is over the limit;
+//                                 at most cfg.maxSize bytes are written in that case
+func copyEntry(w io.Writer, r io.Reader, fileHeader *tar.Header, archiveName string, cfg *config) (int64, error) {
+	if cfg.maxSize <= 0 {
+		return copyBuffered(w, r, cfg)
+	}
+	if fileHeader.Size > cfg.maxSize {
+		return 0, &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("%w: declares %d bytes, limit is %d", ErrSizeExceeded, fileHeader.Size, cfg.maxSize)}
+	}
+	n, err := copyBuffered(w, io.LimitReader(r, cfg.maxSize), cfg)
+	if err != nil {
+		return n, err
+	}
+	// Anything left past the limit means the header understated the size.
+	if extra, _ := io.CopyN(ioutil.Discard, r, 1); extra > 0 {
+		return n, &ExtractError{Op: OpTar, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("%w: over %d bytes", ErrSizeExceeded, cfg.maxSize)}
+	}
+	return n, nil
+}
+
+// copyBuffered(io.Writer, io.Reader, *config) -- io.Copy through a cfg.bufferSize buffer, if one is set
+func copyBuffered(w io.Writer, r io.Reader, cfg *config) (int64, error) {
+	if cfg.bufferSize == nil {
+		return io.Copy(w, r)
+	}
+	// Hiding io.ReaderFrom and io.WriterTo makes io.CopyBuffer use the buffer.
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, make([]byte, *cfg.bufferSize))
+}
+
+// checkBufferSize(string, *config) -- Rejects a WithBufferSize that is not positive
+func checkBufferSize(archiveName string, cfg *config) error {
+	if cfg.bufferSize != nil && *cfg.bufferSize <= 0 {
+		return &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: fmt.Errorf("buffer size %d is not positive", *cfg.bufferSize)}
+	}
+	return nil
+}
+
+// progressReader reports the bytes read through it to a progress callback.
+type progressReader struct {
+	r        io.Reader
+	done     *int64 // shared across the files of one extraction
+	total    int64
+	progress func(bytesDone, bytesTotal int64)
+}
+
+// Read([]byte) -- Reads from the underlying reader and reports the running total
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		*p.done += int64(n)
+		p.progress(*p.done, p.total)
+	}
+	return n, err
+}
+
+// totalLimitReader fails with ErrSizeExceeded once more than limit bytes
+// have been read from the decompressed tar stream.
+type totalLimitReader struct {
+	r           io.Reader
+	limit       int64
+	read        int64
+	archiveName string
+}
+
+// Read([]byte) -- Reads from the underlying stream, counting bytes against the limit
+func (l *totalLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		n -= int(l.read - l.limit)
+		return n, &ExtractError{Op: OpTar, ArchiveName: l.archiveName, Err: fmt.Errorf("%w: decompresses to over %d bytes in total", ErrSizeExceeded, l.limit)}
+	}
+	return n, err
+}
+
+// ctxReader fails with the context's error once it is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read([]byte) -- Reads from the underlying stream unless the context is done
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := contextError(c.ctx); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// contextError(context.Context) -- Returns ctx.Err(), or context.DeadlineExceeded once ctx's deadline has passed
+// The deadline is compared with the clock directly: the timer that sets
+// ctx.Err() may not get to run while a walk keeps the processor busy.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// isRegular(*tar.Header) -- Reports whether a tar entry is a regular file
+// The type flag decides, as FileInfo reports hardlinks as regular files too.
+// GNU sparse entries count as regular; tar.Reader expands their holes to
+// zeros, so bodies must always be read through it rather than the raw stream.
+func isRegular(fileHeader *tar.Header) bool {
+	switch fileHeader.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		return true
+	}
+	return false
+}
+
+// sameEntry(string, string) -- Reports whether a tar entry name refers to the requested path
+// Both are cleaned as in FS first, so "./config/app.json", "/config/app.json",
+// and "config/app.json" all match one another.
+func sameEntry(name, filePath string) bool {
+	return name == filePath || fsName(name) == fsName(filePath)
+}
+
+// defaultEncodings are tried in order when decoding an archive string, so
+// both the standard and URL-safe alphabets work with or without padding.
+var defaultEncodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.URLEncoding,
+	base64.RawStdEncoding,
+	base64.RawURLEncoding,
+}
+
+// decodeArchive(*string, string, *config) -- Decodes a base64 archive held in a string as a stream
+// The decoded bytes are never buffered as a whole.  Each encoding is first
+// checked against the start and end of the string, so a wrong alphabet or
+// padding falls back to the next encoding; corruption elsewhere surfaces
+// as an OpDecode error during the walk.
+// Input:
+//         archive     *string		-- base64 archive
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings; cfg.encodings are tried in order
+// Output:
+//         io.Reader		-- reader over the decoded archive bytes; also an
+//                                 io.Seeker that can rewind to the start
+//         err          error		-- set if the archive is nil or no encoding decodes it;
+//                                 reports the error from the first encoding
+func decodeArchive(archive *string, archiveName string, cfg *config) (io.Reader, error) {
+	if archive == nil {
+		return nil, &ExtractError{Op: OpDecode, ArchiveName: archiveName, Err: errors.New("nil archive")}
+	}
+	skipSpace := cfg.ignoreWhitespace && !cfg.strict
+
+	var firstErr error
+	for _, enc := range cfg.encodings {
+		var err error
+		if cfg.strict {
+			enc = enc.Strict()
+			if i := strings.IndexAny(*archive, "\r\n"); i >= 0 {
+				err = fmt.Errorf("line break at input byte %d", i)
+			}
+		}
+		rdata := &base64Reader{archive: *archive, enc: enc, skipSpace: skipSpace}
+		if err == nil {
+			err = rdata.check()
+		}
+		if err == nil {
+			return rdata, nil
+		}
+		if firstErr == nil {
+			if err == io.ErrUnexpectedEOF {
+				// The whitespace-skipping stream decoder cannot say where the input went wrong.
+				if _, serr := enc.DecodeString(stripSpace(*archive)); serr != nil {
+					err = serr
+				}
+			}
+			firstErr = err
+		}
+	}
+	cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT DECODE "+archiveName+"!!!"))
+	return nil, &ExtractError{Op: OpDecode, ArchiveName: archiveName, Err: firstErr}
+}
+
+// decodeBase64(*string, string, *config) -- Decodes a base64 archive held in a string into bytes
+// Input:
+//         archive     *string		-- base64 archive
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings; cfg.encodings are tried in order
+// Output:
+//         []byte		-- decoded archive bytes, still compressed
+//         err          error		-- set if the archive is nil or no encoding decodes it;
+//                                 reports the error from the first encoding
+func decodeBase64(archive *string, archiveName string, cfg *config) ([]byte, error) {
+	if archive == nil {
+		return nil, &ExtractError{Op: OpDecode, ArchiveName: archiveName, Err: errors.New("nil archive")}
+	}
+	skipSpace := cfg.ignoreWhitespace && !cfg.strict
+	b64 := *archive
+
+	var firstErr error
+	for _, enc := range cfg.encodings {
+		if cfg.strict {
+			enc = enc.Strict()
+		}
+		data, err := enc.DecodeString(b64)
+		if skipSpace && spaceAt(b64, err) {
+			// Only copy the string once whitespace is known to be present.
+			b64 = stripSpace(b64)
+			data, err = enc.DecodeString(b64)
+		}
+		if err == nil && cfg.strict && enc.EncodedLen(len(data)) != len(b64) {
+			// The decoder skips line breaks, so a canonical input re-encodes to exactly its own length.
+			err = fmt.Errorf("%d base64 characters do not encode %d bytes exactly", len(b64), len(data))
+		}
+		if err == nil {
+			return data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT DECODE "+archiveName+"!!!"))
+	return nil, &ExtractError{Op: OpDecode, ArchiveName: archiveName, Err: firstErr}
+}
+
+// base64Chunk is how many base64 characters base64Reader decodes at a time.
+const base64Chunk = 32 << 10
+
+// base64Reader decodes a base64 string as a stream, a chunk at a time.
+// Seeking to the start restarts decoding, which lets hardlinks be resolved
+// with a second walk.
+type base64Reader struct {
+	archive   string
+	enc       *base64.Encoding
+	skipSpace bool // switch to a whitespace-skipping decoder on hitting whitespace
+
+	r      io.Reader // whitespace-skipping decoder for the rest of the string, once needed
+	pos    int       // offset of the next undecoded character
+	padded bool      // the last chunk ended in padding
+	src    []byte
+	dst    []byte
+	out    []byte // decoded bytes not yet read
+}
+
+// rewind() -- Restarts decoding from the beginning of the string
+func (b *base64Reader) rewind() {
+	b.r, b.pos, b.padded, b.out = nil, 0, false, nil
+}
+
+// Read([]byte) -- Reads decoded bytes
+func (b *base64Reader) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		if b.r != nil {
+			return b.r.Read(p)
+		}
+		if b.pos >= len(b.archive) {
+			return 0, io.EOF
+		}
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// fill() -- Decodes the next chunk of the string into b.out
+func (b *base64Reader) fill() error {
+	rest := b.archive[b.pos:]
+	if b.padded {
+		// Only line breaks may follow padding.
+		if i := strings.IndexFunc(rest, func(r rune) bool { return r != '\r' && r != '\n' }); i >= 0 {
+			return base64.CorruptInputError(b.pos + i)
+		}
+		b.pos = len(b.archive)
+		return nil
+	}
+
+	// Decode only whole quanta unless this is the last chunk; the decoder
+	// skips line breaks, so they do not count toward a quantum.
+	chunk := rest
+	if len(chunk) > base64Chunk {
+		chunk = chunk[:base64Chunk]
+	}
+	significant := len(chunk)
+	if strings.IndexByte(chunk, '\n') >= 0 || strings.IndexByte(chunk, '\r') >= 0 {
+		significant -= strings.Count(chunk, "\r") + strings.Count(chunk, "\n")
+	}
+	if len(chunk) < len(rest) {
+		for significant%4 != 0 {
+			chunk = chunk[:len(chunk)-1]
+			if c := rest[len(chunk)]; c != '\r' && c != '\n' {
+				significant--
+			}
+		}
+	}
+
+	if b.dst == nil {
+		b.src = make([]byte, 0, base64Chunk)
+		b.dst = make([]byte, b.enc.DecodedLen(base64Chunk))
+	}
+	b.src = append(b.src[:0], chunk...)
+	n, err := b.enc.Decode(b.dst, b.src)
+	if corrupt, ok := err.(base64.CorruptInputError); ok {
+		if b.skipSpace && isSpace(b.src[corrupt]) {
+			// The chunk starts on a quantum boundary, so decoding can resume from it.
+			b.r = base64.NewDecoder(b.enc, &spaceSkipper{r: strings.NewReader(rest)})
+			return nil
+		}
+		return base64.CorruptInputError(int64(b.pos) + int64(corrupt))
+	}
+	if err != nil {
+		return err
+	}
+	b.padded = n < b.enc.DecodedLen(significant)
+	b.out = b.dst[:n]
+	b.pos += len(chunk)
+	return nil
+}
+
+// check() -- Decodes the first and last chunks to see whether b.enc fits the string, then rewinds
+func (b *base64Reader) check() error {
+	defer b.rewind()
+	b.rewind()
+	if len(b.archive) <= 2*base64Chunk {
+		_, err := io.Copy(ioutil.Discard, b)
+		return err
+	}
+	if err := b.fill(); err != nil {
+		return err
+	}
+	if b.r != nil {
+		// Whitespace found; the whitespace-skipping decoder has to see it all.
+		_, err := io.Copy(ioutil.Discard, b)
+		return err
+	}
+
+	// Start the tail on a quantum boundary, counting only characters the decoder keeps.
+	tail := len(b.archive) - base64Chunk
+	significant := tail - strings.Count(b.archive[:tail], "\r") - strings.Count(b.archive[:tail], "\n")
+	for ; significant%4 != 0; tail++ {
+		if c := b.archive[tail]; c != '\r' && c != '\n' {
+			significant++
+		}
+	}
+	b.pos, b.padded = tail, false
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		// Other whitespace or misplaced padding shifts the quanta, so the tail
+		// alone cannot tell; decode everything to be sure and to locate the error.
+		b.rewind()
+		_, err = io.Copy(ioutil.Discard, b)
+		return err
+	}
+	return nil
+}
+
+// Seek(int64, int) -- Rewinds to the start; no other seek is supported
+func (b *base64Reader) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("base64 stream can only be rewound to the start")
+	}
+	b.rewind()
+	return 0, nil
+}
+
+// spaceSkipper drops ASCII whitespace from a base64 stream before it reaches the decoder.
+type spaceSkipper struct {
+	r io.Reader
+}
+
+// Read([]byte) -- Reads from the underlying stream, keeping only non-whitespace bytes
+func (s *spaceSkipper) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		kept := 0
+		for _, c := range p[:n] {
+			if !isSpace(c) {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// newStreamDecoder(io.Reader) -- Returns a base64 decoder over r, rewindable if r is an io.Seeker
+func newStreamDecoder(r io.Reader) io.Reader {
+	dec := base64.NewDecoder(base64.StdEncoding, &spaceSkipper{r: r})
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return dec
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return dec
+	}
+	return &rewindDecoder{src: r, seeker: seeker, start: start, dec: dec}
+}
+
+// rewindDecoder decodes a seekable base64 stream and, like base64Reader,
+// can be rewound to where decoding began.
+type rewindDecoder struct {
+	src    io.Reader
+	seeker io.Seeker
+	start  int64 // offset of src when decoding began
+	dec    io.Reader
+}
+
+// Read([]byte) -- Reads decoded bytes
+func (d *rewindDecoder) Read(p []byte) (int, error) {
+	return d.dec.Read(p)
+}
+
+// Seek(int64, int) -- Rewinds to the start; no other seek is supported
+func (d *rewindDecoder) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("base64 stream can only be rewound to the start")
+	}
+	if _, err := d.seeker.Seek(d.start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	d.dec = base64.NewDecoder(base64.StdEncoding, &spaceSkipper{r: d.src})
+	return 0, nil
+}
+
+// stripSpace(string) -- Returns s without ASCII whitespace
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && isSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// spaceAt(string, error) -- Reports whether err is a base64 error at a whitespace byte of s
+func spaceAt(s string, err error) bool {
+	corrupt, ok := err.(base64.CorruptInputError)
+	return ok && int(corrupt) < len(s) && isSpace(s[corrupt])
+}
+
+// isSpace(byte) -- Reports whether c is ASCII whitespace
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// openStream(io.Reader, string, *config) -- Opens the decompression layer of an archive stream
+// Input:
+//         rdata        io.Reader	-- base64-decoded archive stream
+//         archiveName  string		-- archive name used in logs and errors
+//         cfg         *config		-- decoding settings
+// Output:
+//         io.Reader		-- raw tar stream, release with closeStream
+//         err          error		-- set if the stream is not in cfg.format
+func openStream(rdata io.Reader, archiveName string, cfg *config) (io.Reader, error) {
+	var raw io.Reader
+	var err error
+	// Decompressors read the stream header as they are made.
+	if perr := guard(archiveName, cfg, func() error { raw, err = cfg.decompress(rdata); return nil }); perr != nil {
+		return nil, perr
+	}
+	if err != nil {
+		var corrupt base64.CorruptInputError
+		if errors.As(err, &corrupt) {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT DECODE "+archiveName+"!!!"))
+			return nil, decodeError(archiveName, err)
+		}
+		if cfg.format == OpDecompress {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT DECOMPRESS "+archiveName+"!!!"))
+		} else {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- "+archiveName+" IS NOT "+strings.ToUpper(cfg.format)+"!!!"))
+		}
+		return nil, &ExtractError{Op: cfg.format, ArchiveName: archiveName, Err: err}
+	}
+	// Concatenated gzip members are read as one stream unless asked otherwise.
+	if zr, ok := raw.(*pooledGzip); ok && cfg.singleStream {
+		zr.Multistream(false)
+	}
+	return raw, nil
+}
+
+// gzipPool holds idle *gzip.Reader values; a reader's window and Huffman
+// tables make up most of the per-call allocation of a gzip extraction.
+var gzipPool sync.Pool
+
+// pooledGzip returns its gzip reader to gzipPool when closed.
+type pooledGzip struct {
+	*gzip.Reader
+}
+
+// Read([]byte) -- Reads decompressed bytes; fails once closed, as the reader may be in use elsewhere
+func (p *pooledGzip) Read(b []byte) (int, error) {
+	if p.Reader == nil {
+		return 0, errors.New("read from closed gzip stream")
+	}
+	return p.Reader.Read(b)
+}
+
+// Close() -- Closes the gzip reader and returns it to the pool; later calls do nothing
+func (p *pooledGzip) Close() error {
+	if p.Reader == nil {
+		return nil
+	}
+	err := p.Reader.Close()
+	gzipPool.Put(p.Reader)
+	p.Reader = nil
+	return err
+}
+
+// gunzip(io.Reader) -- Decompressor for gzip archives, reusing pooled readers
+func gunzip(r io.Reader) (io.Reader, error) {
+	if zr, ok := gzipPool.Get().(*gzip.Reader); ok {
+		if err := zr.Reset(r); err != nil {
+			gzipPool.Put(zr)
+			return nil, err
+		}
+		return &pooledGzip{zr}, nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledGzip{zr}, nil
+}
+
+// untar(io.Reader) -- Decompressor for uncompressed tar archives
+func untar(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// closeStream(io.Reader, io.Reader) -- Drains and closes a decompressing reader
+// Input:
+//         raw          io.Reader	-- reader returned by openStream
+//         drain        io.Reader	-- reader over raw to drain before closing, nil = close only
+// Output:
+//         err          error		-- set if trailing data fails to decompress (e.g. the
+//                                 gzip CRC-32/size footer does not match) or the
+//                                 stream cannot be closed
+func closeStream(raw, drain io.Reader) error {
+	var err error
+	if drain != nil {
+		// Checksums such as the gzip footer are only verified once the stream is read to EOF.
+		_, err = io.Copy(ioutil.Discard, drain)
+	}
+	if closer, ok := raw.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 // extractFileParams(...interface{}) -- Unload variadic args for ExtractFile
 // Input: 
 //         vArgs ...interface{} -- variadic inputs
@@ -87,7 +2300,7 @@ func ExtractFile(vArgs ...interface{}) ([]byte, error) {
 //         err          error		-- set if incorrect number of arguments are passed
 func extractFileParams(vArgs ...interface{}) (archivePtr *string, archiveName string, filePath string, logName string, err error) {
 	// Initialize optional args
-	logName = "[go-selftgz]"
+	logName = DefaultLogName
 
 	// Verify enough parameters
 	if 2 > len(vArgs) {
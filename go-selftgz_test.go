@@ -0,0 +1,2504 @@
+/*****************************************************************/
+/* go-selftgz_test.go -- Tests extraction of files from base64   */
+/* TGZ archives.                                                 */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// checkExtractError fails t unless err is an *ExtractError with op wrapping want.
+func checkExtractError(t *testing.T, err error, op string, want error) {
+	t.Helper()
+	var extractErr *ExtractError
+	if !errors.As(err, &extractErr) {
+		t.Fatalf("error = %v, want an *ExtractError", err)
+	}
+	if extractErr.Op != op {
+		t.Errorf("Op = %q, want %q (error %v)", extractErr.Op, op, err)
+	}
+	if want != nil && !errors.Is(err, want) {
+		t.Errorf("error = %v, want it to wrap %v", err, want)
+	}
+}
+
+func TestExtractFileFollow(t *testing.T) {
+	archive := fixture(t,
+		fileEntry("etc/real.conf", "real"),
+		symlinkEntry("etc/app.conf", "real.conf"),
+		symlinkEntry("app.conf", "etc/app.conf"),
+		symlinkEntry("abs.conf", "/etc/real.conf"),
+		symlinkEntry("loop/a", "b"),
+		symlinkEntry("loop/b", "a"),
+		symlinkEntry("dangling", "nowhere"),
+	)
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		op      string
+		wantErr error
+	}{
+		{name: "regular file", path: "etc/real.conf", want: "real"},
+		{name: "relative link", path: "etc/app.conf", want: "real"},
+		{name: "chained links", path: "app.conf", want: "real"},
+		{name: "absolute link", path: "abs.conf", want: "real"},
+		{name: "loop", path: "loop/a", op: OpLink, wantErr: ErrSymlinkLoop},
+		{name: "dangling link", path: "dangling", op: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "missing", path: "missing", op: OpNotFound, wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileFollow(archive, "fixture", tt.path)
+			if tt.wantErr != nil {
+				checkExtractError(t, err, tt.op, tt.wantErr)
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileFollow(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHardlinks(t *testing.T) {
+	archive := fixture(t,
+		fileEntry("data/orig", "payload"),
+		hardlinkEntry("data/link", "data/orig"),
+		hardlinkEntry("data/chain", "data/link"),
+	)
+	b64Path := filepath.Join(t.TempDir(), "archive.b64")
+	if err := ioutil.WriteFile(b64Path, []byte(*archive), 0644); err != nil {
+		t.Fatal(err)
+	}
+	byKey := func(files map[string][]byte, err error, key string) ([]byte, error) {
+		return files[key], err
+	}
+
+	tests := []struct {
+		name    string
+		extract func(path string) ([]byte, error)
+	}{
+		{"ExtractFile", func(p string) ([]byte, error) { return ExtractFile(archive, "fixture", p) }},
+		{"ExtractFileBytes", func(p string) ([]byte, error) { return ExtractFileBytes([]byte(*archive), "fixture", p) }},
+		{"ExtractFileReader", func(p string) ([]byte, error) { return ExtractFileReader(strings.NewReader(*archive), "fixture", p) }},
+		{"ExtractFromFS", func(p string) ([]byte, error) {
+			return ExtractFromFS(fstest.MapFS{"archive.b64": {Data: []byte(*archive)}}, "archive.b64", "fixture", p)
+		}},
+		{"ExtractFileFromPath", func(p string) ([]byte, error) { return ExtractFileFromPath(b64Path, "fixture", p) }},
+		{"Archive.File", func(p string) ([]byte, error) {
+			a, err := NewArchive(archive, "fixture")
+			if err != nil {
+				return nil, err
+			}
+			return a.File(p)
+		}},
+		{"Cache.Extract", func(p string) ([]byte, error) { return NewCache(1).Extract(archive, "fixture", p) }},
+		{"FS", func(p string) ([]byte, error) {
+			fsys, err := FS(archive, "fixture")
+			if err != nil {
+				return nil, err
+			}
+			return fs.ReadFile(fsys, p)
+		}},
+		{"OpenFile", func(p string) ([]byte, error) {
+			rc, err := OpenFile(archive, "fixture", p)
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}},
+		{"ExtractToWriter", func(p string) ([]byte, error) {
+			var buf bytes.Buffer
+			_, err := ExtractToWriter(archive, "fixture", p, &buf)
+			return buf.Bytes(), err
+		}},
+		{"ExtractToDir", func(p string) ([]byte, error) {
+			dest := t.TempDir()
+			if err := ExtractToDir(archive, "fixture", dest, quiet); err != nil {
+				return nil, err
+			}
+			return ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(p)))
+		}},
+		{"ExtractAll", func(p string) ([]byte, error) {
+			files, err := ExtractAll(archive, "fixture")
+			return byKey(files, err, p)
+		}},
+		{"ExtractFiles", func(p string) ([]byte, error) {
+			files, err := ExtractFiles(archive, "fixture", []string{p})
+			return byKey(files, err, p)
+		}},
+		{"ExtractGlob", func(p string) ([]byte, error) {
+			files, err := ExtractGlob(archive, "fixture", p)
+			return byKey(files, err, p)
+		}},
+		{"ExtractPrefix", func(p string) ([]byte, error) {
+			files, err := ExtractPrefix(archive, "fixture", p)
+			return byKey(files, err, p)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range []string{"data/link", "data/chain"} {
+				got, err := tt.extract(p)
+				if err != nil || string(got) != "payload" {
+					t.Errorf("%s(%q) = %q, %v; want %q", tt.name, p, got, err, "payload")
+				}
+			}
+		})
+	}
+
+	if n, err := CountFiles(archive, "fixture"); err != nil || n != 1 {
+		t.Errorf("CountFiles() = %d, %v; want 1", n, err)
+	}
+	if _, err := ExtractFileReader(io.MultiReader(strings.NewReader(*archive)), "fixture", "data/link"); err == nil {
+		t.Error("ExtractFileReader() resolved a hardlink in an unseekable stream")
+	} else {
+		checkExtractError(t, err, OpLink, nil)
+	}
+}
+
+func TestHardlinkWithoutTarget(t *testing.T) {
+	archive := fixture(t, fileEntry("orig", "payload"), hardlinkEntry("dangling", "missing"))
+	tests := []struct {
+		name    string
+		extract func() error
+	}{
+		{"ExtractFile", func() error { _, err := ExtractFile(archive, "fixture", "dangling"); return err }},
+		{"ExtractAll", func() error { _, err := ExtractAll(archive, "fixture"); return err }},
+		{"ExtractToWriter", func() error { _, err := ExtractToWriter(archive, "fixture", "dangling", ioutil.Discard); return err }},
+		{"OpenFile", func() error { _, err := OpenFile(archive, "fixture", "dangling"); return err }},
+		{"Archive.File", func() error {
+			a, err := NewArchive(archive, "fixture")
+			if err != nil {
+				return err
+			}
+			_, err = a.File("dangling")
+			return err
+		}},
+		{"ExtractToDir", func() error { return ExtractToDir(archive, "fixture", t.TempDir(), quiet) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkExtractError(t, tt.extract(), OpLink, nil)
+		})
+	}
+}
+
+func TestExtractFileLayered(t *testing.T) {
+	base := fixture(t, fileEntry("app.conf", "base"), fileEntry("base.only", "base"))
+	overlay := fixture(t, fileEntry("app.conf", "overlay"), fileEntry("overlay.only", "overlay"))
+	layers := []*string{base, overlay}
+	tests := []struct {
+		name string
+		path string
+		opts []Option
+		want string
+	}{
+		{name: "overlay shadows base", path: "app.conf", want: "overlay"},
+		{name: "first match keeps base", path: "app.conf", opts: []Option{WithFirstMatch()}, want: "base"},
+		{name: "only in base", path: "base.only", want: "base"},
+		{name: "only in overlay", path: "overlay.only", opts: []Option{WithFirstMatch()}, want: "overlay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileLayered(layers, "layers", tt.path, append(tt.opts, quiet)...)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileLayered(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+
+	_, err := ExtractFileLayered(layers, "layers", "missing", quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+}
+
+func TestExtractFilePriority(t *testing.T) {
+	corrupt := "!!!!"
+	layers := map[string]*string{
+		"base":    fixture(t, fileEntry("app.json", "base"), fileEntry("base.json", "base only")),
+		"overlay": fixture(t, fileEntry("app.json", "overlay")),
+		"corrupt": &corrupt,
+	}
+	tests := []struct {
+		name      string
+		order     []string
+		path      string
+		want      string
+		wantLayer string
+	}{
+		{name: "overlay first", order: []string{"overlay", "base"}, path: "app.json", want: "overlay", wantLayer: "overlay"},
+		{name: "base first", order: []string{"base", "overlay"}, path: "app.json", want: "base", wantLayer: "base"},
+		{name: "falls through to base", order: []string{"overlay", "base"}, path: "base.json", want: "base only", wantLayer: "base"},
+		{name: "later layers unread", order: []string{"overlay", "corrupt"}, path: "app.json", want: "overlay", wantLayer: "overlay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, layer, err := ExtractFilePriority(layers, tt.order, "layers", tt.path)
+			if err != nil || string(got) != tt.want || layer != tt.wantLayer {
+				t.Errorf("ExtractFilePriority(%q) = %q, %q, %v; want %q, %q", tt.order, got, layer, err, tt.want, tt.wantLayer)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		order   []string
+		path    string
+		wantOp  string
+		wantErr error
+	}{
+		{name: "missing everywhere", order: []string{"overlay", "base"}, path: "nope", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "empty order", path: "app.json", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "unknown layer", order: []string{"overlay", "extra"}, path: "base.json", wantOp: OpArgument},
+		{name: "corrupt layer", order: []string{"corrupt", "base"}, path: "app.json", wantOp: OpDecode},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, layer, err := ExtractFilePriority(layers, tt.order, "layers", tt.path, quiet)
+			checkExtractError(t, err, tt.wantOp, tt.wantErr)
+			if got != nil || layer != "" {
+				t.Errorf("ExtractFilePriority(%q) = %q, %q; want nothing", tt.order, got, layer)
+			}
+		})
+	}
+}
+
+func TestNameVariants(t *testing.T) {
+	extractors := []struct {
+		name    string
+		extract func(archive *string, path string) ([]byte, error)
+	}{
+		{"ExtractFile", func(archive *string, p string) ([]byte, error) { return ExtractFile(archive, "fixture", p) }},
+		{"ExtractFiles", func(archive *string, p string) ([]byte, error) {
+			files, err := ExtractFiles(archive, "fixture", []string{p})
+			return files[p], err
+		}},
+		{"Archive.File", func(archive *string, p string) ([]byte, error) {
+			a, err := NewArchive(archive, "fixture")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := a.Stat(p); err != nil {
+				return nil, err
+			}
+			return a.File(p)
+		}},
+		{"Cache.Extract", func(archive *string, p string) ([]byte, error) { return NewCache(1).Extract(archive, "fixture", p) }},
+	}
+	stored := []string{"config/app.json", "./config/app.json", "/config/app.json"}
+	requested := []string{"config/app.json", "./config/app.json", "/config/app.json", "config//app.json"}
+	for _, ex := range extractors {
+		for _, s := range stored {
+			archive := fixture(t, fileEntry(s, "{}"))
+			for _, r := range requested {
+				got, err := ex.extract(archive, r)
+				if err != nil || string(got) != "{}" {
+					t.Errorf("%s(%q) with %q stored = %q, %v; want %q", ex.name, r, s, got, err, "{}")
+				}
+			}
+		}
+	}
+
+	archive := fixture(t, fileEntry("./a.txt", "1"), fileEntry("a.txt", "2"), fileEntry("b.txt", "3"))
+	dups, err := FindDuplicates(archive, "fixture")
+	if err != nil || len(dups) != 1 || dups["a.txt"] != 2 {
+		t.Errorf("FindDuplicates() = %v, %v; want map[a.txt:2]", dups, err)
+	}
+	files, err := ExtractFiles(archive, "fixture", []string{"a.txt", "./a.txt"})
+	if err != nil || string(files["a.txt"]) != "1" || string(files["./a.txt"]) != "1" {
+		t.Errorf("ExtractFiles() = %q, %v; want the first a.txt under both paths", files, err)
+	}
+}
+
+func TestExtractFileFold(t *testing.T) {
+	archive := fixture(t,
+		dirEntry("Config/"),
+		fileEntry("Config/App.JSON", "first"),
+		fileEntry("config/app.json", "second"),
+		fileEntry("./Docs/README", "readme"),
+	)
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+		wantErr  error
+	}{
+		{name: "differing case", filePath: "CONFIG/app.Json", want: "first"},
+		{name: "first in archive order", filePath: "config/app.json", want: "first"},
+		{name: "leading dot slash", filePath: "docs/readme", want: "readme"},
+		{name: "missing", filePath: "config/other.json", wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileFold(archive, "fixture", tt.filePath)
+			if tt.wantErr != nil {
+				checkExtractError(t, err, OpNotFound, tt.wantErr)
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileFold(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFileString(t *testing.T) {
+	archive := fixture(t, fileEntry("query.sql", "SELECT 1;\n"), fileEntry("empty.txt", ""), fileEntry("bin", "\xff\xfe"))
+	tests := []struct {
+		name    string
+		file    string
+		opts    []Option
+		want    string
+		wantOp  string
+		wantErr error
+	}{
+		{name: "text", file: "query.sql", want: "SELECT 1;\n"},
+		{name: "empty file", file: "empty.txt", want: ""},
+		{name: "binary as is", file: "bin", want: "\xff\xfe"},
+		{name: "binary with WithValidUTF8", file: "bin", opts: []Option{WithValidUTF8()}, wantOp: OpVerify, wantErr: ErrNotUTF8},
+		{name: "missing", file: "nope", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileString(archive, "fixture", tt.file, append([]Option{quiet}, tt.opts...)...)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFileBytes(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	names, err := ListFiles(archive, "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range append(names, "missing") {
+		t.Run(name, func(t *testing.T) {
+			want, wantErr := ExtractFile(archive, "fixture", name)
+			got, err := ExtractFileBytes([]byte(*archive), "fixture", name)
+			if !bytes.Equal(got, want) || (err == nil) != (wantErr == nil) {
+				t.Errorf("ExtractFileBytes(%q) = %q, %v; want %q, %v as from ExtractFile", name, got, err, want, wantErr)
+			}
+		})
+	}
+	t.Run("URL encoding", func(t *testing.T) {
+		raw, err := base64.StdEncoding.DecodeString(*archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only ExtractFile tries the other alphabets.
+		url := base64.RawURLEncoding.EncodeToString(raw)
+		if _, err := ExtractFile(&url, "fixture", "app/readme.txt"); err != nil {
+			t.Fatalf("ExtractFile() error = %v", err)
+		}
+		if _, err := ExtractFileBytes([]byte(url), "fixture", "app/readme.txt"); err == nil {
+			t.Error("ExtractFileBytes() of URL-safe base64 succeeded, want an error")
+		}
+	})
+}
+
+func TestExtractFileWithContentType(t *testing.T) {
+	big := strings.Repeat("x", 600) + "<html>" // past the 512 sniffed bytes
+	archive := fixture(t, append(sampleEntries(),
+		fileEntry("web/index.html", "<p>hi</p>"),
+		fileEntry("web/STYLE.CSS", "body {}"),
+		fileEntry("web/page.zzz", "<!DOCTYPE html><p>hi</p>"),
+		fileEntry("bin/blob", "\x00\x01\x02\x03"),
+		fileEntry("bin/late", big),
+		fileEntry("empty", ""),
+	)...)
+	tests := []struct {
+		filePath string
+		want     string
+	}{
+		{filePath: "app/config.json", want: "application/json"},
+		{filePath: "web/index.html", want: "text/html; charset=utf-8"},
+		{filePath: "web/STYLE.CSS", want: "text/css; charset=utf-8"},
+		// Without a known extension the contents are sniffed.
+		{filePath: "img/logo", want: "image/png"},
+		{filePath: "web/page.zzz", want: "text/html; charset=utf-8"},
+		{filePath: "app/readme.txt", want: "text/plain; charset=utf-8"},
+		{filePath: "bin/blob", want: "application/octet-stream"},
+		{filePath: "bin/late", want: "text/plain; charset=utf-8"},
+		{filePath: "empty", want: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			data, contentType, err := ExtractFileWithContentType(archive, "fixture", tt.filePath)
+			if err != nil || contentType != tt.want {
+				t.Errorf("ExtractFileWithContentType() content type = %q, %v; want %q", contentType, err, tt.want)
+			}
+			if want, _ := Extract(archive, "fixture", tt.filePath); !bytes.Equal(data, want) {
+				t.Errorf("data = %q, want %q", data, want)
+			}
+		})
+	}
+
+	data, contentType, err := ExtractFileWithContentType(archive, "fixture", "nope", quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+	if data != nil || contentType != "" {
+		t.Errorf("ExtractFileWithContentType(missing) = %q, %q; want nothing", data, contentType)
+	}
+}
+
+func TestExtractFileOrDefault(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	corrupt := "!!!!" + *archive
+	truncated := base64.StdEncoding.EncodeToString(gzipBytes(t, tarBytes(t, sampleEntries()...))[:60])
+	def := []byte("default")
+	tests := []struct {
+		name     string
+		archive  *string
+		filePath string
+		opts     []Option
+		want     string
+		wantWarn bool // a WARNING line is logged
+	}{
+		{name: "present", archive: archive, filePath: "app/readme.txt", want: "hello"},
+		{name: "present via hardlink", archive: archive, filePath: "app/copy.json", want: `{"debug":true}`},
+		{name: "present and empty", archive: fixture(t, fileEntry("empty", "")), filePath: "empty", want: ""},
+		{name: "absent", archive: archive, filePath: "app/override.json", want: "default"},
+		{name: "corrupt archive", archive: &corrupt, filePath: "app/readme.txt", want: "default", wantWarn: true},
+		{name: "truncated archive", archive: &truncated, filePath: "img/logo", want: "default", wantWarn: true},
+		{name: "over WithMaxSize", archive: archive, filePath: "app/config.json", opts: []Option{WithMaxSize(4)}, want: "default", wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			got := ExtractFileOrDefault(tt.archive, "fixture", tt.filePath, def, append(tt.opts, WithLogger(logger))...)
+			if string(got) != tt.want {
+				t.Errorf("ExtractFileOrDefault() = %q, want %q", got, tt.want)
+			}
+			warned := false
+			for _, line := range logger.lines {
+				if strings.Contains(line, "WARNING -- USING DEFAULT FOR "+tt.filePath) {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarn {
+				t.Errorf("logged %q; want a warning: %t", logger.lines, tt.wantWarn)
+			}
+		})
+	}
+	if got := ExtractFileOrDefault(archive, "fixture", "missing", nil); got != nil {
+		t.Errorf("ExtractFileOrDefault(nil default) = %q, want nil", got)
+	}
+}
+
+func TestMustExtractFile(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	corrupt := "!!!!"
+	tests := []struct {
+		name      string
+		archive   *string
+		filePath  string
+		opts      []Option
+		want      string
+		wantPanic string // substring of the panic message, "" for success
+		wantErr   error  // sentinel wrapped by the message, checked by text
+	}{
+		{name: "present", archive: archive, filePath: "app/readme.txt", want: "hello"},
+		{name: "leading ./", archive: archive, filePath: "./app-b/run.sh", want: "#!/bin/sh\n"},
+		{name: "hardlink", archive: archive, filePath: "app/copy.json", want: `{"debug":true}`},
+		{name: "missing", archive: archive, filePath: "app/missing.json", wantPanic: `SelfTGZ: MustExtractFile("app/missing.json")`, wantErr: ErrFileNotFound},
+		{name: "corrupt archive", archive: &corrupt, filePath: "app/readme.txt", wantPanic: `SelfTGZ: MustExtractFile("app/readme.txt")`},
+		{name: "over WithMaxSize", archive: archive, filePath: "app/config.json", opts: []Option{WithMaxSize(4)}, wantPanic: "SelfTGZ: MustExtractFile", wantErr: ErrSizeExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if tt.wantPanic == "" {
+					if r != nil {
+						t.Errorf("panicked with %v", r)
+					}
+					return
+				}
+				msg, ok := r.(string)
+				if !ok || !strings.Contains(msg, tt.wantPanic) || (tt.wantErr != nil && !strings.Contains(msg, tt.wantErr.Error())) {
+					t.Errorf("panicked with %#v, want a message containing %q and %v", r, tt.wantPanic, tt.wantErr)
+				}
+			}()
+			got := MustExtractFile(tt.archive, "fixture", tt.filePath, append(tt.opts, quiet)...)
+			if tt.wantPanic != "" {
+				t.Errorf("MustExtractFile() = %q, want a panic", got)
+			} else if string(got) != tt.want {
+				t.Errorf("MustExtractFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLEncoding(t *testing.T) {
+	// A random body does not compress, so its encoding is sure to use every
+	// character, including the ones the two alphabets disagree on.
+	body := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(body)
+	raw := gzipBytes(t, tarBytes(t, fileEntry("data.bin", string(body))))
+	if url := base64.URLEncoding.EncodeToString(raw); !strings.ContainsAny(url, "-_") {
+		t.Fatal("fixture encodes without URL-only characters")
+	}
+	tests := []struct {
+		name    string
+		enc     *base64.Encoding
+		wantURL bool // whether ExtractFileURLEncoding accepts it
+	}{
+		{name: "URL padded", enc: base64.URLEncoding, wantURL: true},
+		{name: "URL unpadded", enc: base64.RawURLEncoding, wantURL: true},
+		{name: "standard padded", enc: base64.StdEncoding},
+		{name: "standard unpadded", enc: base64.RawStdEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := tt.enc.EncodeToString(raw)
+			got, err := ExtractFileURLEncoding(&archive, "fixture", "data.bin")
+			if tt.wantURL && (err != nil || !bytes.Equal(got, body)) {
+				t.Errorf("ExtractFileURLEncoding() = %d bytes, %v; want the %d-byte body", len(got), err, len(body))
+			}
+			if !tt.wantURL {
+				checkExtractError(t, err, OpDecode, nil)
+			}
+			// ExtractFile tries the standard alphabet, then the URL-safe one.
+			if got, err := ExtractFile(&archive, "fixture", "data.bin"); err != nil || !bytes.Equal(got, body) {
+				t.Errorf("ExtractFile() = %d bytes, %v; want the %d-byte body", len(got), err, len(body))
+			}
+		})
+	}
+}
+
+func TestExtractFileTar(t *testing.T) {
+	plain := base64.StdEncoding.EncodeToString(tarBytes(t, sampleEntries()...))
+	tests := []struct {
+		filePath string
+		want     string
+		wantOp   string // Op of the expected *ExtractError, "" for success
+	}{
+		{filePath: "app/readme.txt", want: "hello"},
+		{filePath: "app-b/run.sh", want: "#!/bin/sh\n"},
+		{filePath: "app/copy.json", want: `{"debug":true}`},
+		{filePath: "missing", wantOp: OpNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			got, err := ExtractFileTar(&plain, "fixture", tt.filePath)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+			} else if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractFileTar(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+		})
+	}
+	t.Run("gzipped", func(t *testing.T) {
+		_, err := ExtractFileTar(fixture(t, sampleEntries()...), "fixture", "app/readme.txt")
+		checkExtractError(t, err, OpTar, nil)
+	})
+}
+
+func TestExtractFileWith(t *testing.T) {
+	gzipped := fixture(t, sampleEntries()...)
+	plain := base64.StdEncoding.EncodeToString(tarBytes(t, sampleEntries()...))
+	tests := []struct {
+		name       string
+		archive    *string
+		decompress Decompressor
+	}{
+		{name: "gzip", archive: gzipped, decompress: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{name: "identity for plain tar", archive: &plain, decompress: func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{name: "nil for plain tar", archive: &plain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for filePath, want := range files("app/readme.txt", "hello", "app/copy.json", `{"debug":true}`) {
+				if got, err := ExtractFileWith(tt.archive, "fixture", filePath, tt.decompress); err != nil || string(got) != string(want) {
+					t.Errorf("ExtractFileWith(%q) = %q, %v; want %q", filePath, got, err, want)
+				}
+			}
+			_, err := ExtractFileWith(tt.archive, "fixture", "missing", tt.decompress)
+			checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+		})
+	}
+}
+
+// cancelAfter is a context cancelled after its first after calls to Err,
+// which lets a test cancel at a chosen point in a walk.
+type cancelAfter struct {
+	context.Context
+	after, calls int
+}
+
+func (c *cancelAfter) Err() error {
+	c.calls++
+	if c.calls > c.after {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestExtractFileContext(t *testing.T) {
+	// The 8 MiB body takes hundreds of reads, each checking the context.
+	archive := fixture(t, fileEntry("big.bin", string(make([]byte, 8<<20))), fileEntry("last.txt", "last"))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		file    string
+		want    []byte
+		wantErr error
+	}{
+		{name: "not cancelled", ctx: context.Background(), file: "last.txt", want: []byte("last")},
+		{name: "cancelled before the walk", ctx: cancelled, file: "last.txt", wantErr: context.Canceled},
+		{name: "cancelled while skipping an entry", ctx: &cancelAfter{Context: context.Background(), after: 20}, file: "last.txt", wantErr: context.Canceled},
+		{name: "cancelled while reading the file", ctx: &cancelAfter{Context: context.Background(), after: 20}, file: "big.bin", wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileContext(tt.ctx, archive, "fixture", tt.file, quiet)
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("got %d bytes, want %q", len(got), tt.want)
+			}
+			// Returning promptly means the context was not checked much
+			// after it was cancelled.
+			if c, ok := tt.ctx.(*cancelAfter); ok && c.calls > c.after+5 {
+				t.Errorf("context checked %d times after cancellation", c.calls-c.after)
+			}
+		})
+	}
+}
+
+func TestExtractFileTimeout(t *testing.T) {
+	// The wanted file follows 64 MiB of bodies, which the walk has to
+	// decompress to reach it.
+	var entries []entry
+	zeros := string(make([]byte, 4<<20))
+	for i := 0; i < 16; i++ {
+		entries = append(entries, fileEntry(fmt.Sprintf("big/%02d.bin", i), zeros))
+	}
+	archive := fixture(t, append(entries, fileEntry("last.txt", "last"))...)
+
+	// Time the fastest of a few runs with a generous deadline to scale the
+	// tiny one by.
+	fastest := time.Hour
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		got, err := ExtractFileTimeout(time.Minute, archive, "fixture", "last.txt")
+		if err != nil || string(got) != "last" {
+			t.Fatalf("ExtractFileTimeout(1m) = %q, %v; want last", got, err)
+		}
+		if elapsed := time.Since(start); elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+
+	tests := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"zero", 0},
+		{"already passed", -time.Second},
+		{"one nanosecond", time.Nanosecond},
+		{"one microsecond", time.Microsecond},
+		{"a hundredth of the extraction", fastest / 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFileTimeout(tt.d, archive, "fixture", "last.txt", quiet)
+			if err != context.DeadlineExceeded || got != nil {
+				t.Errorf("ExtractFileTimeout(%v) = %d bytes, %v; want context.DeadlineExceeded", tt.d, len(got), err)
+			}
+		})
+	}
+	_, err := ExtractFileTimeout(time.Minute, archive, "fixture", "missing", quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+}
+
+func TestExtractFileHeader(t *testing.T) {
+	script := fileEntry("bin/run.sh", "#!/bin/sh\n")
+	script.hdr.Mode = 0755
+	older := fileEntry("etc/app.conf", "debug = true")
+	older.hdr.Mode, older.hdr.ModTime = 0600, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := fixture(t, script, older, hardlinkEntry("bin/again", "bin/run.sh"))
+	tests := []struct {
+		filePath string
+		want     string
+		wantName string // Name of the returned header
+		wantMode int64
+		wantTime time.Time
+	}{
+		{filePath: "bin/run.sh", want: "#!/bin/sh\n", wantName: "bin/run.sh", wantMode: 0755, wantTime: fixtureTime},
+		{filePath: "./etc/app.conf", want: "debug = true", wantName: "etc/app.conf", wantMode: 0600, wantTime: older.hdr.ModTime},
+		// A hardlink has no body, so the data and header are its target's.
+		{filePath: "bin/again", want: "#!/bin/sh\n", wantName: "bin/run.sh", wantMode: 0755, wantTime: fixtureTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			got, hdr, err := ExtractFileHeader(archive, "fixture", tt.filePath)
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("ExtractFileHeader(%q) = %q, %v; want %q", tt.filePath, got, err, tt.want)
+			}
+			if hdr.Name != tt.wantName || hdr.Mode != tt.wantMode || hdr.Size != int64(len(tt.want)) || !hdr.ModTime.Equal(tt.wantTime) {
+				t.Errorf("header = %q mode %o size %d modified %v; want %q mode %o size %d modified %v",
+					hdr.Name, hdr.Mode, hdr.Size, hdr.ModTime, tt.wantName, tt.wantMode, len(tt.want), tt.wantTime)
+			}
+		})
+	}
+	t.Run("missing", func(t *testing.T) {
+		_, hdr, err := ExtractFileHeader(archive, "fixture", "bin/other")
+		checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+		if hdr != nil {
+			t.Errorf("header = %v, want nil", hdr)
+		}
+	})
+}
+
+func TestStatFile(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	tests := []struct {
+		filePath string
+		wantName string
+		wantSize int64
+		wantType byte
+	}{
+		{filePath: "app/readme.txt", wantName: "app/readme.txt", wantSize: 5, wantType: tar.TypeReg},
+		{filePath: "app-b/run.sh", wantName: "./app-b/run.sh", wantSize: 10, wantType: tar.TypeReg},
+		{filePath: "app", wantName: "app/", wantType: tar.TypeDir},
+		// Links are not followed: the header is the link's own.
+		{filePath: "app/latest", wantName: "app/latest", wantType: tar.TypeSymlink},
+		{filePath: "app/copy.json", wantName: "app/copy.json", wantType: tar.TypeLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			hdr, err := StatFile(archive, "fixture", tt.filePath)
+			if err != nil || hdr.Name != tt.wantName || hdr.Size != tt.wantSize || hdr.Typeflag != tt.wantType {
+				t.Errorf("StatFile(%q) = %+v, %v; want %q of type %q and size %d", tt.filePath, hdr, err, tt.wantName, tt.wantType, tt.wantSize)
+			}
+		})
+	}
+	t.Run("missing", func(t *testing.T) {
+		_, err := StatFile(archive, "fixture", "app/missing")
+		checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+	})
+
+	t.Run("body not read", func(t *testing.T) {
+		const size = 16 << 20
+		big := fixture(t, fileEntry("big.bin", strings.Repeat("x", size)))
+		allocated := func(f func()) uint64 {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			f()
+			runtime.ReadMemStats(&after)
+			return after.TotalAlloc - before.TotalAlloc
+		}
+		var hdr *tar.Header
+		var err error
+		got := allocated(func() { hdr, err = StatFile(big, "fixture", "big.bin") })
+		if err != nil || hdr.Size != size {
+			t.Fatalf("StatFile() = %+v, %v; want size %d", hdr, err, size)
+		}
+		if got > size/16 {
+			t.Errorf("StatFile() allocated %d bytes for a %d-byte body", got, size)
+		}
+		if read := allocated(func() { _, err = Extract(big, "fixture", "big.bin", quiet) }); err != nil || read < size {
+			t.Errorf("Extract() allocated %d bytes, %v; want at least the %d-byte body, so the check above means something", read, err, size)
+		}
+	})
+}
+
+func TestFileOwnership(t *testing.T) {
+	nobody := fileEntry("var/spool", "s")
+	nobody.hdr.Uid, nobody.hdr.Gid, nobody.hdr.Uname, nobody.hdr.Gname = 65534, 65534, "nobody", "nogroup"
+	numeric := fileEntry("numeric", "n")
+	numeric.hdr.Uid, numeric.hdr.Gid = 501, 20
+	dir := dirEntry("srv/")
+	dir.hdr.Uid, dir.hdr.Gid, dir.hdr.Uname, dir.hdr.Gname = 33, 33, "www-data", "www-data"
+	archive := fixture(t, append(sampleEntries(), nobody, numeric, dir)...)
+
+	tests := []struct {
+		filePath     string
+		uid, gid     int
+		uname, gname string
+	}{
+		{filePath: "app-b/run.sh", uid: 1000, gid: 100, uname: "deploy", gname: "staff"},
+		{filePath: "./app-b/run.sh", uid: 1000, gid: 100, uname: "deploy", gname: "staff"},
+		{filePath: "app/readme.txt"},
+		{filePath: "var/spool", uid: 65534, gid: 65534, uname: "nobody", gname: "nogroup"},
+		{filePath: "numeric", uid: 501, gid: 20},
+		{filePath: "srv/", uid: 33, gid: 33, uname: "www-data", gname: "www-data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			uid, gid, uname, gname, err := FileOwnership(archive, "fixture", tt.filePath)
+			if err != nil || uid != tt.uid || gid != tt.gid || uname != tt.uname || gname != tt.gname {
+				t.Errorf("FileOwnership() = %d, %d, %q, %q, %v; want %d, %d, %q, %q",
+					uid, gid, uname, gname, err, tt.uid, tt.gid, tt.uname, tt.gname)
+			}
+		})
+	}
+
+	uid, gid, uname, gname, err := FileOwnership(archive, "fixture", "nope", quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+	if uid != 0 || gid != 0 || uname != "" || gname != "" {
+		t.Errorf("FileOwnership(missing) = %d, %d, %q, %q; want zero values", uid, gid, uname, gname)
+	}
+	corrupt := "!!!!"
+	_, _, _, _, err = FileOwnership(&corrupt, "fixture", "app-b/run.sh", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+}
+
+func TestContainsFile(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	corrupt := "!!!!" + *archive
+	tests := []struct {
+		name    string
+		archive *string
+		file    string
+		want    bool
+		wantOp  string
+	}{
+		{name: "file", archive: archive, file: "app/config.json", want: true},
+		{name: "leading ./", archive: archive, file: "app-b/run.sh", want: true},
+		{name: "directory", archive: archive, file: "app/", want: true},
+		{name: "symlink", archive: archive, file: "app/latest", want: true},
+		{name: "hardlink", archive: archive, file: "app/copy.json", want: true},
+		{name: "missing", archive: archive, file: "app/nope.json"},
+		{name: "prefix of a name", archive: archive, file: "app/config"},
+		{name: "corrupt archive", archive: &corrupt, file: "app/config.json", wantOp: OpDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ContainsFile(tt.archive, "fixture", tt.file, quiet)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ContainsFile(%q) = %t, want %t", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive *string
+		want    int
+	}{
+		// The directory, symlink and hardlink are not counted.
+		{name: "sample", archive: fixture(t, sampleEntries()...), want: 7},
+		{name: "directories only", archive: fixture(t, dirEntry("a/"), dirEntry("a/b/")), want: 0},
+		{name: "empty archive", archive: fixture(t), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CountFiles(tt.archive, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("CountFiles() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	if n, err := CountFiles(&corrupt, "fixture", quiet); err == nil || n != 0 {
+		t.Errorf("CountFiles(corrupt) = %d, %v; want 0 and an error", n, err)
+	}
+}
+
+func TestExtractByBasename(t *testing.T) {
+	archive := fixture(t,
+		dirEntry("conf/"),
+		fileEntry("conf/app.json", "nested"),
+		fileEntry("x/conf", "file"),
+		fileEntry("a/app.json", "first"),
+		fileEntry("b/app.json", "second"),
+		hardlinkEntry("c/linked", "x/conf"),
+	)
+	tests := []struct {
+		base     string
+		want     string
+		wantName string
+	}{
+		{base: "conf", want: "file", wantName: "x/conf"},
+		{base: "app.json", want: "nested", wantName: "conf/app.json"},
+		{base: "linked", want: "file", wantName: "c/linked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			got, name, err := ExtractByBasename(archive, "fixture", tt.base)
+			if err != nil || string(got) != tt.want || name != tt.wantName {
+				t.Errorf("ExtractByBasename(%q) = %q, %q, %v; want %q, %q", tt.base, got, name, err, tt.want, tt.wantName)
+			}
+		})
+	}
+
+	all, err := ExtractAllByBasename(archive, "fixture", "app.json")
+	if err != nil || len(all) != 3 {
+		t.Errorf("ExtractAllByBasename() = %q, %v; want 3 files", all, err)
+	}
+	_, _, err = ExtractByBasename(archive, "fixture", "missing")
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+}
+
+// panicReader panics on every read, standing in for a decoder that trips
+// over malformed input.
+type panicReader struct{}
+
+func (panicReader) Read([]byte) (int, error) { panic("malformed input") }
+
+// readerFunc, writerFunc, and loggerFunc adapt functions to io.Reader,
+// io.Writer, and Logger.
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type loggerFunc func(string, ...interface{})
+
+func (f loggerFunc) Printf(format string, args ...interface{}) { f(format, args...) }
+
+func TestMalformedInput(t *testing.T) {
+	valid := *fixture(t, fileEntry("a.txt", "a"))
+	raw, _ := base64.StdEncoding.DecodeString(valid)
+	encode := func(b []byte) *string {
+		s := base64.StdEncoding.EncodeToString(b)
+		return &s
+	}
+	archives := map[string]*string{
+		"truncated gzip": encode(raw[:len(raw)/2]),
+		"gzip of junk":   encode(gzipBytes(t, bytes.Repeat([]byte{0xff}, 1024))),
+		"junk":           encode(bytes.Repeat([]byte{0x1f, 0x8b, 0x08, 0x00}, 64)),
+		"not base64":     func() *string { s := "!!!! not base64 ????"; return &s }(),
+	}
+	// Recompress and GzipMetadata never parse the tar stream, so for them it
+	// is enough not to panic.
+	calls := []struct {
+		name     string
+		call     func(archive *string) error
+		walksTar bool
+	}{
+		{"ExtractFile", func(a *string) error { _, err := ExtractFile(a, "fixture", "a.txt"); return err }, true},
+		{"ListFiles", func(a *string) error { _, err := ListFiles(a, "fixture"); return err }, true},
+		{"OpenFile", func(a *string) error {
+			rc, err := OpenFile(a, "fixture", "a.txt")
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			_, err = ioutil.ReadAll(rc)
+			return err
+		}, true},
+		{"NewIterator", func(a *string) error {
+			it, err := NewIterator(a, "fixture")
+			if err != nil {
+				return err
+			}
+			for it.Next() {
+				if _, err := ioutil.ReadAll(it); err != nil {
+					return err
+				}
+			}
+			return it.Err()
+		}, true},
+		{"Recompress", func(a *string) error { _, err := Recompress(a, CompressionGzip, CompressionZstd); return err }, false},
+		{"GzipMetadata", func(a *string) error { _, err := GzipMetadata(a); return err }, false},
+		{"Validate", func(a *string) error { return Validate(a, "fixture") }, true},
+	}
+	for _, c := range calls {
+		for name, archive := range archives {
+			if err := c.call(archive); err == nil && c.walksTar {
+				t.Errorf("%s(%s) succeeded, want an error", c.name, name)
+			}
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "a"))
+	sound := tarBytes(t, fileEntry("a.txt", strings.Repeat("a", 1024)))
+	// decoder returns a config whose built-in decompressor yields stream,
+	// standing in for a library decoder that trips over malformed input.
+	decoder := func(stream func() io.Reader) *config {
+		cfg := defaultConfig()
+		cfg.quiet = true
+		cfg.decompress = func(io.Reader) (io.Reader, error) { return stream(), nil }
+		return cfg
+	}
+	panicking := decoder(func() io.Reader { return panicReader{} })
+	// A sound header whose body panics.
+	badBody := decoder(func() io.Reader { return io.MultiReader(bytes.NewReader(sound[:512]), panicReader{}) })
+	// A sound archive whose trailing data panics.
+	badTrailer := decoder(func() io.Reader { return io.MultiReader(bytes.NewReader(sound), panicReader{}) })
+	badHeader := defaultConfig()
+	badHeader.quiet = true
+	badHeader.decompress = func(io.Reader) (io.Reader, error) { panic("bad gzip header") }
+	walk := func(cfg *config, visit func(*tar.Header, io.Reader) error) func() error {
+		return func() error { return walkArchive(archive, "fixture", cfg, visit) }
+	}
+	ignore := func(*tar.Header, io.Reader) error { return nil }
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"decompressor", walk(badHeader, ignore)},
+		{"tar header", walk(panicking, ignore)},
+		{"entry body", walk(badBody, func(_ *tar.Header, r io.Reader) error {
+			_, err := ioutil.ReadAll(r)
+			return err
+		})},
+		{"unread entry body", walk(badBody, ignore)},
+		{"trailing data", walk(badTrailer, ignore)},
+		{"openEntry", func() error { _, _, err := openEntry(archive, "fixture", "a.txt", panicking); return err }},
+		{"openEntry decompressor", func() error { _, _, err := openEntry(archive, "fixture", "a.txt", badHeader); return err }},
+		{"Iterator.Next", func() error {
+			it := &Iterator{tarDat: tar.NewReader(panicReader{}), raw: panicReader{}, archiveName: "fixture", cfg: panicking}
+			if it.Next() {
+				t.Error("Next() = true on a panicking stream")
+			}
+			return it.Err()
+		}},
+		{"Iterator.Read", func() error {
+			stream := io.MultiReader(bytes.NewReader(sound[:512]), panicReader{})
+			it := &Iterator{tarDat: tar.NewReader(stream), raw: stream, archiveName: "fixture", cfg: panicking}
+			if !it.Next() {
+				t.Fatalf("Next() = false: %v", it.Err())
+			}
+			_, err := it.Read(make([]byte, 1))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkExtractError(t, tt.call(), OpTar, ErrCorruptArchive)
+		})
+	}
+}
+
+// errCallerPanic is the value panicked with by the caller code in TestCallerPanics.
+var errCallerPanic = errors.New("caller bug")
+
+func TestCallerPanics(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "alpha"), fileEntry("b.txt", "beta"))
+	corrupt := "!!!!"
+	bomb := func() { panic(errCallerPanic) }
+	gunzipThen := func(after func()) Decompressor {
+		return func(r io.Reader) (io.Reader, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return readerFunc(func(p []byte) (int, error) {
+				after()
+				return zr.Read(p)
+			}), nil
+		}
+	}
+
+	tests := []struct {
+		name string
+		call func()
+	}{
+		{"WalkFiles callback", func() {
+			WalkFiles(archive, "fixture", func(*tar.Header, io.Reader) error { bomb(); return nil })
+		}},
+		{"WalkFiles callback after reading", func() {
+			WalkFiles(archive, "fixture", func(_ *tar.Header, r io.Reader) error {
+				ioutil.ReadAll(r)
+				bomb()
+				return nil
+			})
+		}},
+		{"filter", func() {
+			ExtractToDir(archive, "fixture", t.TempDir(), WithFilter(func(*tar.Header) bool { bomb(); return true }))
+		}},
+		{"progress", func() {
+			ExtractToDir(archive, "fixture", t.TempDir(), WithProgress(func(int64, int64) { bomb() }))
+		}},
+		{"writer", func() {
+			ExtractToWriter(archive, "fixture", "a.txt", writerFunc(func([]byte) (int, error) { bomb(); return 0, nil }))
+		}},
+		{"Logger", func() {
+			Extract(&corrupt, "fixture", "a.txt", WithLogger(loggerFunc(func(string, ...interface{}) { bomb() })))
+		}},
+		{"Decompressor", func() {
+			ExtractFileWith(archive, "fixture", "a.txt", func(io.Reader) (io.Reader, error) { bomb(); return nil, nil })
+		}},
+		{"Decompressor reader", func() { ExtractFileWith(archive, "fixture", "b.txt", gunzipThen(bomb)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != errCallerPanic {
+					t.Errorf("recovered %v, want the caller's own panic", r)
+				}
+			}()
+			tt.call()
+		})
+	}
+
+	// The wrapping leaves a well-behaved Decompressor working as before.
+	if got, err := ExtractFileWith(archive, "fixture", "b.txt", gunzipThen(func() {})); err != nil || string(got) != "beta" {
+		t.Errorf("ExtractFileWith() = %q, %v", got, err)
+	}
+}
+
+func TestLongNames(t *testing.T) {
+	long := strings.Repeat("d/", 60) + strings.Repeat("x", 80) // 200 characters
+	formats := []struct {
+		name   string
+		format tar.Format
+	}{
+		{"PAX", tar.FormatPAX},
+		{"GNU", tar.FormatGNU},
+	}
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			e := fileEntry(long, "deep")
+			e.hdr.Format = f.format
+			archive := fixture(t, fileEntry("short", "s"), e)
+
+			got, err := ExtractFile(archive, "fixture", long)
+			if err != nil || string(got) != "deep" {
+				t.Errorf("ExtractFile() = %q, %v; want %q", got, err, "deep")
+			}
+			names, err := ListFiles(archive, "fixture")
+			if err != nil || len(names) != 2 || names[1] != long {
+				t.Errorf("ListFiles() = %q, %v; want [short %s]", names, err, long)
+			}
+		})
+	}
+}
+
+// wrap(string, int, string) -- Inserts sep after every width characters of s
+func wrap(s string, width int, sep string) string {
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString(sep)
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// payload(int) -- Returns n reproducible pseudo-random bytes
+func payload(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestBase64Reader(t *testing.T) {
+	// Decoded sizes whose encodings end just short of, on, and just past
+	// one, two, and three chunks, and a few that are not a multiple of 3.
+	quantum := base64Chunk / 4 * 3
+	var sizes []int
+	for _, k := range []int{1, 2, 3} {
+		sizes = append(sizes, k*quantum-3, k*quantum-1, k*quantum, k*quantum+1, k*quantum+3)
+	}
+	sizes = append(sizes, 0, 1, 2, 3, 1000)
+
+	encodings := []struct {
+		name   string
+		encode func([]byte) string
+	}{
+		{"std", base64.StdEncoding.EncodeToString},
+		{"std unpadded", base64.RawStdEncoding.EncodeToString},
+		{"url", base64.URLEncoding.EncodeToString},
+		{"url unpadded", base64.RawURLEncoding.EncodeToString},
+		{"LF every 76", func(b []byte) string { return wrap(base64.StdEncoding.EncodeToString(b), 76, "\n") }},
+		{"CRLF every 64", func(b []byte) string { return wrap(base64.StdEncoding.EncodeToString(b), 64, "\r\n") }},
+		{"CRLF straddling chunks", func(b []byte) string {
+			return wrap(base64.StdEncoding.EncodeToString(b), base64Chunk-1, "\r\n")
+		}},
+		{"trailing newline", func(b []byte) string { return base64.StdEncoding.EncodeToString(b) + "\n" }},
+		{"spaces", func(b []byte) string { return wrap(base64.URLEncoding.EncodeToString(b), 1000, " \t") }},
+	}
+	for _, enc := range encodings {
+		for _, size := range sizes {
+			want := payload(size)
+			archive := enc.encode(want)
+			rdata, err := decodeArchive(&archive, "fixture", defaultConfig())
+			if err != nil {
+				t.Errorf("%s, %d bytes: decodeArchive() error = %v", enc.name, size, err)
+				continue
+			}
+			got, err := ioutil.ReadAll(rdata)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%s, %d bytes: read %d bytes, %v; want the %d input bytes", enc.name, size, len(got), err, len(want))
+				continue
+			}
+			// Rewinding restarts the stream, as hardlink resolution relies on.
+			if _, err := rdata.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if again, err := ioutil.ReadAll(rdata); err != nil || !bytes.Equal(again, want) {
+				t.Errorf("%s, %d bytes: read %d bytes after rewinding, %v", enc.name, size, len(again), err)
+			}
+		}
+	}
+}
+
+func TestBase64ReaderErrors(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString(payload(3 * base64Chunk))
+	tests := []struct {
+		name    string
+		archive string
+		offset  int64 // expected base64.CorruptInputError, -1 for any error
+	}{
+		{name: "bad character in first chunk", archive: good[:10] + "*" + good[11:], offset: 10},
+		{name: "bad character in last chunk", archive: good[:len(good)-10] + "*" + good[len(good)-9:], offset: int64(len(good) - 10)},
+		{name: "data after padding", archive: base64.StdEncoding.EncodeToString([]byte("ab")) + "QUJD", offset: -1},
+		{name: "mixed alphabets", archive: "-_-_" + good[4:len(good)/2] + "++//" + good[len(good)/2+4:], offset: -1},
+		{name: "single character quantum", archive: good[:len(good)-3], offset: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.quiet = true
+			_, err := decodeArchive(&tt.archive, "fixture", cfg)
+			checkExtractError(t, err, OpDecode, nil)
+			var corrupt base64.CorruptInputError
+			if tt.offset >= 0 && (!errors.As(err, &corrupt) || int64(corrupt) != tt.offset) {
+				t.Errorf("error = %v, want base64.CorruptInputError(%d)", err, tt.offset)
+			}
+		})
+	}
+}
+
+// BenchmarkBase64Reader compares base64Reader, which decodes the string a
+// chunk at a time without copying it, with base64.NewDecoder over a
+// strings.Reader, the obvious alternative.
+func BenchmarkBase64Reader(b *testing.B) {
+	archive := wrap(base64.StdEncoding.EncodeToString(payload(1<<20)), 76, "\n")
+	b.Run("base64Reader", func(b *testing.B) {
+		b.SetBytes(int64(len(archive)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rdata, err := decodeArchive(&archive, "bench", defaultConfig())
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(ioutil.Discard, rdata); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("base64.NewDecoder", func(b *testing.B) {
+		b.SetBytes(int64(len(archive)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(archive))
+			if _, err := io.Copy(ioutil.Discard, dec); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestPooledGzip(t *testing.T) {
+	body := strings.Repeat("pooled ", 100)
+	zr, err := gunzip(bytes.NewReader(gzipBytes(t, []byte(body))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil || string(got) != body {
+		t.Fatalf("read %d bytes, %v; want %d", len(got), err, len(body))
+	}
+	closer := zr.(io.Closer)
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The reader is back in the pool; a stale handle must neither read
+	// through it nor put it back a second time.
+	if _, err := zr.Read(make([]byte, 1)); err == nil {
+		t.Error("Read after Close succeeded")
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if _, err := gunzip(strings.NewReader("not gzip")); err == nil {
+		t.Error("gunzip accepted a stream without a gzip header")
+	}
+}
+
+// TestPooledGzipConcurrent is meant for go test -race: readers handed out
+// by gzipPool must never be shared between extractions.
+func TestPooledGzipConcurrent(t *testing.T) {
+	archives := make([]*string, 8)
+	for i := range archives {
+		archives[i] = fixture(t, fileEntry("data", strings.Repeat(string(rune('a'+i)), 4<<10)))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				n := (g + i) % len(archives)
+				data, err := Extract(archives[n], "fixture", "data", quiet)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want := strings.Repeat(string(rune('a'+n)), 4<<10); string(data) != want {
+					errs <- fmt.Errorf("archive %d returned %.8q..., want %.8q...", n, data, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkGzipPool compares Extract, which reuses pooled gzip readers,
+// with a decompressor that allocates a fresh gzip.Reader every call.
+func BenchmarkGzipPool(b *testing.B) {
+	archive := fixture(b, fileEntry("data", strings.Repeat("x", 4<<10)))
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Extract(archive, "bench", "data", quiet); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("gzip.NewReader", func(b *testing.B) {
+		fresh := func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ExtractFileWith(archive, "bench", "data", fresh); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSparseFiles(t *testing.T) {
+	const size = 3*512 + 100
+	raw := append(gnuSparseBytes("disk.img", size,
+		sparseFragment{offset: 0, data: "head"},
+		sparseFragment{offset: 1000, data: "middle"},
+		sparseFragment{offset: size - 4, data: "tail"},
+	), tarBytes(t, fileEntry("after", "next entry"))...)
+	encoded := base64.StdEncoding.EncodeToString(gzipBytes(t, raw))
+	archive := &encoded
+
+	want := make([]byte, size)
+	copy(want, "head")
+	copy(want[1000:], "middle")
+	copy(want[size-4:], "tail")
+
+	readers := []struct {
+		name string
+		read func() ([]byte, error)
+	}{
+		{"ExtractFile", func() ([]byte, error) { return ExtractFile(archive, "fixture", "disk.img") }},
+		{"ExtractAll", func() ([]byte, error) {
+			files, err := ExtractAll(archive, "fixture", quiet)
+			return files["disk.img"], err
+		}},
+		{"Archive.File", func() ([]byte, error) {
+			a, err := NewArchive(archive, "fixture")
+			if err != nil {
+				return nil, err
+			}
+			return a.File("disk.img")
+		}},
+		{"OpenFile", func() ([]byte, error) {
+			rc, err := OpenFile(archive, "fixture", "disk.img")
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return ioutil.ReadAll(rc)
+		}},
+		{"ExtractToWriter", func() ([]byte, error) {
+			var buf bytes.Buffer
+			_, err := ExtractToWriter(archive, "fixture", "disk.img", &buf, quiet)
+			return buf.Bytes(), err
+		}},
+		{"ExtractRange", func() ([]byte, error) { return ExtractRange(archive, "fixture", "disk.img", 0, size) }},
+		{"FS", func() ([]byte, error) {
+			fsys, err := FS(archive, "fixture", quiet)
+			if err != nil {
+				return nil, err
+			}
+			return fs.ReadFile(fsys, "disk.img")
+		}},
+		{"ExtractToDir", func() ([]byte, error) {
+			dest := t.TempDir()
+			if err := ExtractToDir(archive, "fixture", dest, quiet); err != nil {
+				return nil, err
+			}
+			return ioutil.ReadFile(filepath.Join(dest, "disk.img"))
+		}},
+	}
+	for _, tt := range readers {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("read %d bytes, want %d with zeros in the holes", len(got), len(want))
+			}
+		})
+	}
+
+	// The entry after the sparse one is still found, so the stored data
+	// was skipped by its stored size, not its logical one.
+	if got, err := ExtractFile(archive, "fixture", "after"); err != nil || string(got) != "next entry" {
+		t.Errorf("ExtractFile(after) = %q, %v", got, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := *fixture(t, fileEntry("a.txt", "a"), fileEntry("b.txt", "b"))
+	tests := []struct {
+		name    string
+		archive string
+	}{
+		{name: "bad character", archive: valid[:20] + "*" + valid[21:]},
+		{name: "truncated base64", archive: valid[:len(valid)-3]},
+	}
+	calls := []struct {
+		name string
+		call func(archive *string) error
+	}{
+		{"Extract", func(a *string) error { _, err := Extract(a, "fixture", "a.txt", quiet); return err }},
+		{"ListFiles", func(a *string) error { _, err := ListFiles(a, "fixture", quiet); return err }},
+		{"ExtractAll", func(a *string) error { _, err := ExtractAll(a, "fixture", quiet); return err }},
+	}
+	for _, tt := range tests {
+		for _, c := range calls {
+			t.Run(tt.name+"/"+c.name, func(t *testing.T) {
+				archive := tt.archive
+				err := c.call(&archive)
+				checkExtractError(t, err, OpDecode, nil)
+				if !strings.Contains(err.Error(), "decode") {
+					t.Errorf("error %q does not mention decoding", err)
+				}
+			})
+		}
+	}
+}
+
+func TestNotGzip(t *testing.T) {
+	encode := func(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+	tests := []struct {
+		name    string
+		archive string
+		want    error
+	}{
+		{name: "tar without gzip", archive: encode(tarBytes(t, fileEntry("a.txt", "a"))), want: gzip.ErrHeader},
+		{name: "plain text", archive: encode([]byte("just some text")), want: gzip.ErrHeader},
+		{name: "empty", archive: "", want: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Extract(&tt.archive, "fixture", "a.txt", quiet)
+			checkExtractError(t, err, OpGzip, tt.want)
+			if !strings.Contains(err.Error(), "gzip") {
+				t.Errorf("error %q does not mention gzip", err)
+			}
+		})
+	}
+}
+
+func TestGzipMetadata(t *testing.T) {
+	body := tarBytes(t, fileEntry("a.txt", "alpha"))
+	stamped := func(h gzip.Header) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Header = h
+		if _, err := zw.Write(body); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	build := gzip.Header{
+		Name:    "assets.tar",
+		Comment: "build 1234 from commit abcdef",
+		ModTime: time.Date(2023, 6, 1, 12, 30, 0, 0, time.UTC),
+		Extra:   []byte("provenance"),
+		OS:      3,
+	}
+	tests := []struct {
+		name   string
+		stream []byte
+		want   gzip.Header
+	}{
+		{name: "stamped", stream: stamped(build), want: build},
+		{name: "name only", stream: stamped(gzip.Header{Name: "assets.tar"}), want: gzip.Header{Name: "assets.tar"}},
+		{name: "unstamped", stream: gzipBytes(t, body), want: gzip.Header{OS: 255}},
+		{name: "first member of several", stream: append(stamped(build), stamped(gzip.Header{Name: "second"})...), want: build},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := base64.StdEncoding.EncodeToString(tt.stream)
+			got, err := GzipMetadata(&archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.want.Name || got.Comment != tt.want.Comment || !got.ModTime.Equal(tt.want.ModTime) ||
+				!bytes.Equal(got.Extra, tt.want.Extra) || got.OS != tt.want.OS {
+				t.Errorf("GzipMetadata() = %+v, want %+v", got, tt.want)
+			}
+			// Reading the header leaves the archive itself readable.
+			if data, err := Extract(&archive, "fixture", "a.txt"); err != nil || string(data) != "alpha" {
+				t.Errorf("Extract() = %q, %v", data, err)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	_, err := GzipMetadata(&corrupt, quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	plain := base64.StdEncoding.EncodeToString(body)
+	_, err = GzipMetadata(&plain, quiet)
+	checkExtractError(t, err, OpGzip, nil)
+}
+
+func TestGzipFooter(t *testing.T) {
+	raw, err := base64.StdEncoding.DecodeString(*fixture(t, fileEntry("a.txt", "a"), fileEntry("b.txt", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+	flip := func(i int) string {
+		b := append([]byte(nil), raw...)
+		b[len(b)+i] ^= 0xff
+		return encode(b)
+	}
+	tests := []struct {
+		name    string
+		archive string
+		want    error
+	}{
+		{name: "bad CRC-32", archive: flip(-8), want: gzip.ErrChecksum},
+		{name: "bad length", archive: flip(-1), want: gzip.ErrChecksum},
+		{name: "cut short", archive: encode(raw[:len(raw)-4]), want: io.ErrUnexpectedEOF},
+	}
+	// The footer is only reached by calls that read the whole archive.
+	calls := []struct {
+		name string
+		call func(archive *string) error
+	}{
+		{"ListFiles", func(a *string) error { _, err := ListFiles(a, "fixture", quiet); return err }},
+		{"ExtractAll", func(a *string) error { _, err := ExtractAll(a, "fixture", quiet); return err }},
+		{"Validate", func(a *string) error { return Validate(a, "fixture") }},
+		{"Extract missing", func(a *string) error { _, err := Extract(a, "fixture", "missing", quiet); return err }},
+	}
+	for _, tt := range tests {
+		for _, c := range calls {
+			t.Run(tt.name+"/"+c.name, func(t *testing.T) {
+				archive := tt.archive
+				checkExtractError(t, c.call(&archive), OpGzip, tt.want)
+			})
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	tests := []struct {
+		name    string
+		extract func() ([]byte, error)
+		want    string
+		wantErr string // substring of the expected error
+	}{
+		{name: "Extract", extract: func() ([]byte, error) { return Extract(archive, "fixture", "app/config.json") }, want: `{"debug":true}`},
+		{name: "Extract missing", extract: func() ([]byte, error) { return Extract(archive, "fixture", "app/nope", quiet) }, wantErr: "file not found"},
+		{name: "ExtractWithLog", extract: func() ([]byte, error) { return ExtractWithLog(archive, "fixture", "app/readme.txt", "[test]") }, want: "hello"},
+		{name: "ExtractFile", extract: func() ([]byte, error) { return ExtractFile(archive, "fixture", "app/readme.txt") }, want: "hello"},
+		{name: "ExtractFile with log name", extract: func() ([]byte, error) { return ExtractFile(archive, "fixture", "app/readme.txt", "[test]") }, want: "hello"},
+		{name: "ExtractFile too few arguments", extract: func() ([]byte, error) { return ExtractFile(archive) }, wantErr: "not enough parameters"},
+		{name: "ExtractFile wrong type", extract: func() ([]byte, error) { return ExtractFile("archive", "fixture", "a") }, wantErr: "not type *string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.extract()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %v; want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		want    []string
+	}{
+		{name: "three files", entries: []entry{fileEntry("c.txt", "c"), fileEntry("a.txt", "a"), fileEntry("b/b.txt", "b")}, want: []string{"c.txt", "a.txt", "b/b.txt"}},
+		{name: "every entry type, names as stored", entries: sampleEntries(), want: []string{
+			"app/", "app/config.json", "app/readme.txt", "app/latest", "app/copy.json", "./app-b/run.sh",
+			"logs/2023-01-02.txt", "logs/2023-1-2.txt", "logs/notes.md", "img/logo",
+		}},
+		{name: "empty archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ListFiles(fixture(t, tt.entries...), "fixture")
+			if err != nil || !equalStrings(got, tt.want) {
+				t.Errorf("ListFiles() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive *string
+		want    []string
+	}{
+		{name: "sample", archive: fixture(t, sampleEntries()...), want: []string{
+			"5 app/", "0 app/config.json", "0 app/readme.txt", "2 app/latest -> config.json", "1 app/copy.json -> app/config.json",
+			"0 ./app-b/run.sh", "0 logs/2023-01-02.txt", "0 logs/2023-1-2.txt", "0 logs/notes.md", "0 img/logo",
+		}},
+		{name: "original order", archive: fixture(t, symlinkEntry("z", "a"), fileEntry("a", "a"), dirEntry("m/")), want: []string{
+			"2 z -> a", "0 a", "5 m/",
+		}},
+		{name: "empty archive", archive: fixture(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := ListEntries(tt.archive, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range headers {
+				kind := fmt.Sprintf("%c %s", h.Typeflag, h.Name)
+				if h.Linkname != "" {
+					kind += " -> " + h.Linkname
+				}
+				got = append(got, kind)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("ListEntries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	_, err := ListEntries(&corrupt, "fixture", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+}
+
+func TestEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive *string
+		want    []string
+	}{
+		{name: "sample", archive: fixture(t, sampleEntries()...), want: []string{
+			`app/ 0 drwxr-xr-x true ""`,
+			`app/config.json 14 -rw-r--r-- false ""`,
+			`app/readme.txt 5 -rw-r--r-- false ""`,
+			`app/latest 0 Lrwxrwxrwx false "config.json"`,
+			`app/copy.json 0 -rw-r--r-- false "app/config.json"`,
+			`./app-b/run.sh 10 -rwxr-xr-x false ""`,
+			`logs/2023-01-02.txt 3 -rw-r--r-- false ""`,
+			`logs/2023-1-2.txt 3 -rw-r--r-- false ""`,
+			`logs/notes.md 7 -rw-r--r-- false ""`,
+			`img/logo 8 -rw-r--r-- false ""`,
+		}},
+		{name: "empty archive", archive: fixture(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Entries(tt.archive, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, fmt.Sprintf("%s %d %v %t %q", e.Name, e.Size, e.Mode, e.IsDir, e.Linkname))
+				if !e.ModTime.Equal(fixtureTime) {
+					t.Errorf("%s: ModTime = %v, want %v", e.Name, e.ModTime, fixtureTime)
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("Entries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	entries, err := Entries(&corrupt, "fixture", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	if entries != nil {
+		t.Errorf("Entries(corrupt) = %v, want nil", entries)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	sample := fixture(t, sampleEntries()...)
+	nested := fixture(t,
+		dirEntry("src/"),
+		fileEntry("src/main.go", "package main"),
+		fileEntry("src/pkg/util/util.go", "package util"),
+		fileEntry("src/pkg/util/util_test.go", "package util"),
+		dirEntry("src/pkg/"),
+		fileEntry("src/pkg/doc.go", "package pkg"),
+		fileEntry("./src/pkg/README", "readme"),
+		fileEntry("srcs.txt", "not a child of src"),
+	)
+	tests := []struct {
+		name    string
+		archive *string
+		dir     string
+		want    []string
+		wantOp  string
+		wantErr error
+	}{
+		{name: "top level", archive: sample, dir: "", want: []string{"5 app", "5 app-b", "5 img", "5 logs"}},
+		{name: "dot", archive: sample, dir: ".", want: []string{"5 app", "5 app-b", "5 img", "5 logs"}},
+		{name: "directory", archive: sample, dir: "app", want: []string{
+			"0 app/config.json", "1 app/copy.json", "2 app/latest", "0 app/readme.txt",
+		}},
+		{name: "trailing slash", archive: sample, dir: "app/", want: []string{
+			"0 app/config.json", "1 app/copy.json", "2 app/latest", "0 app/readme.txt",
+		}},
+		{name: "dot-slash entry", archive: sample, dir: "app-b", want: []string{"0 app-b/run.sh"}},
+		{name: "nested top level", archive: nested, dir: ".", want: []string{"5 src", "0 srcs.txt"}},
+		{name: "subdirectory", archive: nested, dir: "src", want: []string{"0 src/main.go", "5 src/pkg"}},
+		{name: "one subdirectory down", archive: nested, dir: "src/pkg", want: []string{
+			"0 src/pkg/README", "0 src/pkg/doc.go", "5 src/pkg/util",
+		}},
+		{name: "synthesized directory", archive: nested, dir: "./src/pkg/util", want: []string{
+			"0 src/pkg/util/util.go", "0 src/pkg/util/util_test.go",
+		}},
+		{name: "empty directory", archive: fixture(t, dirEntry("empty/")), dir: "empty"},
+		{name: "empty archive", archive: fixture(t), dir: "."},
+		{name: "missing directory", archive: nested, dir: "lib", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "name prefix only", archive: nested, dir: "sr", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "file", archive: nested, dir: "srcs.txt", wantOp: OpNotFound, wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := ReadDir(tt.archive, "fixture", tt.dir, quiet)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range headers {
+				got = append(got, fmt.Sprintf("%c %s", h.Typeflag, h.Name))
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("ReadDir(%q) = %q, want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+
+	// Synthesized directories carry a directory mode; explicit headers keep theirs.
+	headers, err := ReadDir(nested, "fixture", "src", quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range headers {
+		if h.Name == "src/pkg" && !h.FileInfo().IsDir() {
+			t.Errorf("src/pkg mode = %v, want a directory", h.FileInfo().Mode())
+		}
+	}
+
+	corrupt := "!!!!"
+	_, err = ReadDir(&corrupt, "fixture", ".", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+}
+
+func TestWalkFiles(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	errVisit := errors.New("visit failed")
+	tests := []struct {
+		name    string
+		stopAt  string // entry at which fn returns stopErr
+		stopErr error
+		want    []string
+		wantErr error
+	}{
+		{name: "every entry", want: []string{
+			"5 app/", "0 app/config.json:14", "0 app/readme.txt:5", "2 app/latest", "1 app/copy.json",
+			"0 ./app-b/run.sh:10", "0 logs/2023-01-02.txt:3", "0 logs/2023-1-2.txt:3", "0 logs/notes.md:7", "0 img/logo:8",
+		}},
+		{name: "ErrStopWalk", stopAt: "app/readme.txt", stopErr: ErrStopWalk,
+			want: []string{"5 app/", "0 app/config.json:14", "0 app/readme.txt:5"}},
+		{name: "other error", stopAt: "app/latest", stopErr: errVisit,
+			want: []string{"5 app/", "0 app/config.json:14", "0 app/readme.txt:5", "2 app/latest"}, wantErr: errVisit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := WalkFiles(archive, "fixture", func(hdr *tar.Header, r io.Reader) error {
+				visit := fmt.Sprintf("%c %s", hdr.Typeflag, hdr.Name)
+				if hdr.Typeflag == tar.TypeReg {
+					data, err := ioutil.ReadAll(r)
+					if err != nil {
+						return err
+					}
+					visit += fmt.Sprintf(":%d", len(data))
+				}
+				got = append(got, visit)
+				if hdr.Name == tt.stopAt {
+					return tt.stopErr
+				}
+				return nil
+			}, quiet)
+			if err != tt.wantErr {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("visited %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!" + *archive
+	visited := 0
+	err := WalkFiles(&corrupt, "fixture", func(*tar.Header, io.Reader) error { visited++; return nil }, quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	if visited != 0 {
+		t.Errorf("visited %d entries of a corrupt archive", visited)
+	}
+}
+
+func TestExtractAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		want    map[string][]byte
+	}{
+		{name: "every regular file round-trips", entries: sampleEntries(), want: files(
+			"app/config.json", `{"debug":true}`, "app/readme.txt", "hello", "app/copy.json", `{"debug":true}`,
+			"./app-b/run.sh", "#!/bin/sh\n", "logs/2023-01-02.txt", "jan", "logs/2023-1-2.txt", "odd",
+			"logs/notes.md", "# notes", "img/logo", "\x89PNG\r\n\x1a\n",
+		)},
+		{name: "empty file", entries: []entry{fileEntry("empty", "")}, want: files("empty", "")},
+		{name: "only directories and symlinks", entries: []entry{dirEntry("d/"), symlinkEntry("d/l", "x")}, want: files()},
+		{name: "empty archive", want: files()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractAll(fixture(t, tt.entries...), "fixture")
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractAll() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFiles(t *testing.T) {
+	entries := []entry{
+		fileEntry("a.txt", "a"), fileEntry("b.txt", "b"), fileEntry("./c/c.txt", "c"),
+		fileEntry("d.txt", "d"), hardlinkEntry("e.txt", "a.txt"),
+	}
+	archive := fixture(t, entries...)
+	tests := []struct {
+		name    string
+		paths   []string
+		want    map[string][]byte
+		wantErr string // substring
+	}{
+		{name: "three of five", paths: []string{"a.txt", "c/c.txt", "e.txt"}, want: files("a.txt", "a", "c/c.txt", "c", "e.txt", "a")},
+		{name: "keyed as requested", paths: []string{"/a.txt", "./b.txt", "b.txt"}, want: files("/a.txt", "a", "./b.txt", "b", "b.txt", "b")},
+		{name: "none", want: files()},
+		{name: "missing", paths: []string{"a.txt", "x.txt", "y.txt"}, wantErr: `["x.txt" "y.txt"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractFiles(archive, "fixture", tt.paths)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrFileNotFound) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ExtractFiles() error = %v; want ErrFileNotFound listing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractFiles() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	// Stopping at the last requested file leaves the footer unread, so a bad
+	// one only shows when the whole archive has to be read.
+	raw, err := base64.StdEncoding.DecodeString(*archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-8] ^= 0xff
+	badFooter := base64.StdEncoding.EncodeToString(raw)
+	t.Run("bad footer, stopped early", func(t *testing.T) {
+		got, err := ExtractFiles(&badFooter, "fixture", []string{"a.txt", "b.txt"})
+		if err != nil || !reflect.DeepEqual(got, files("a.txt", "a", "b.txt", "b")) {
+			t.Errorf("ExtractFiles() = %q, %v; want a.txt and b.txt", got, err)
+		}
+	})
+	t.Run("bad footer, missing file", func(t *testing.T) {
+		_, err := ExtractFiles(&badFooter, "fixture", []string{"a.txt", "x.txt"})
+		checkExtractError(t, err, OpGzip, gzip.ErrChecksum)
+	})
+}
+
+func TestExtractGlob(t *testing.T) {
+	archive := fixture(t,
+		dirEntry("config/v1/"),
+		fileEntry("config/v1/a.json", "1a"),
+		fileEntry("config/v1/b.json", "1b"),
+		fileEntry("config/v1/notes.txt", "notes"),
+		fileEntry("config/v2/a.json", "2a"),
+		fileEntry("config/v10/a.json", "10a"),
+		fileEntry("./config/v3/a.json", "3a"),
+		hardlinkEntry("config/v2/b.json", "config/v1/b.json"),
+		symlinkEntry("config/v2/c.json", "a.json"),
+	)
+	tests := []struct {
+		name    string
+		pattern string
+		want    map[string][]byte
+	}{
+		{name: "star", pattern: "config/v1/*.json", want: files("config/v1/a.json", "1a", "config/v1/b.json", "1b")},
+		{name: "star does not cross slashes", pattern: "config/*.json", want: files()},
+		{name: "question mark", pattern: "config/v?/a.json", want: files("config/v1/a.json", "1a", "config/v2/a.json", "2a", "./config/v3/a.json", "3a")},
+		{name: "bracket class", pattern: "config/v[2-9]/*", want: files("config/v2/a.json", "2a", "config/v2/b.json", "1b", "./config/v3/a.json", "3a")},
+		{name: "negated class", pattern: "config/v1/[^a]*", want: files("config/v1/b.json", "1b", "config/v1/notes.txt", "notes")},
+		{name: "directories skipped", pattern: "config/v1*", want: files()},
+		{name: "entry with leading ./", pattern: "config/v3/*", want: files("./config/v3/a.json", "3a")},
+		{name: "pattern with leading ./", pattern: "./config/v1/*.json", want: files("config/v1/a.json", "1a", "config/v1/b.json", "1b")},
+		{name: "pattern with leading slash", pattern: "/config/v3/*", want: files("./config/v3/a.json", "3a")},
+		{name: "pattern with trailing slash", pattern: "config/v1/a.json/", want: files("config/v1/a.json", "1a")},
+		{name: "nothing matches", pattern: "nothing/*", want: files()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractGlob(archive, "fixture", tt.pattern)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractGlob(%q) = %q, %v; want %q", tt.pattern, got, err, tt.want)
+			}
+		})
+	}
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := ExtractGlob(archive, "fixture", "config/[")
+		checkExtractError(t, err, OpArgument, path.ErrBadPattern)
+	})
+}
+
+func TestExtractPrefix(t *testing.T) {
+	archive := fixture(t,
+		dirEntry("app-a/"),
+		fileEntry("app-a/bin/run", "run a"),
+		fileEntry("./app-a/etc/conf", "conf a"),
+		hardlinkEntry("app-a/etc/conf.bak", "./app-a/etc/conf"),
+		symlinkEntry("app-a/current", "bin/run"),
+		fileEntry("app-ab/run", "run ab"),
+		fileEntry("app-b/bin/run", "run b"),
+		fileEntry("app-b/lib/a.so", "lib b"),
+		fileEntry("top.txt", "top"),
+	)
+	tests := []struct {
+		name   string
+		prefix string
+		opts   []Option
+		want   map[string][]byte
+	}{
+		{name: "subtree", prefix: "app-a/", want: files(
+			"app-a/bin/run", "run a", "./app-a/etc/conf", "conf a", "app-a/etc/conf.bak", "conf a",
+		)},
+		{name: "without trailing slash", prefix: "app-a", want: files(
+			"app-a/bin/run", "run a", "./app-a/etc/conf", "conf a", "app-a/etc/conf.bak", "conf a",
+		)},
+		{name: "with leading ./", prefix: "./app-b", want: files("app-b/bin/run", "run b", "app-b/lib/a.so", "lib b")},
+		{name: "with leading slash", prefix: "/app-b/", want: files("app-b/bin/run", "run b", "app-b/lib/a.so", "lib b")},
+		{name: "nested", prefix: "app-b/lib", want: files("app-b/lib/a.so", "lib b")},
+		{name: "whole path elements only", prefix: "app", want: files()},
+		{name: "nothing under prefix", prefix: "app-c/", want: files()},
+		{name: "stripped", prefix: "app-a/", opts: []Option{WithStripPrefix("app-a/")}, want: files(
+			"bin/run", "run a", "etc/conf", "conf a", "etc/conf.bak", "conf a",
+		)},
+		{name: "stripped deeper than prefix", prefix: "app-b", opts: []Option{WithStripPrefix("./app-b/bin")}, want: files("run", "run b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPrefix(archive, "fixture", tt.prefix, tt.opts...)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractPrefix(%q) = %q, %v; want %q", tt.prefix, got, err, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	_, err := ExtractPrefix(&corrupt, "fixture", "app-a/", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	_, err = ExtractPrefix(archive, "fixture", "app-a/", WithMaxSize(3), quiet)
+	checkExtractError(t, err, OpTar, ErrSizeExceeded)
+}
+
+func TestExtractRegexp(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	tests := []struct {
+		name string
+		expr string
+		want map[string][]byte
+	}{
+		{name: "anchored", expr: `^logs/\d{4}-\d{2}-\d{2}\.txt$`, want: files("logs/2023-01-02.txt", "jan")},
+		{name: "unanchored", expr: `notes`, want: files("logs/notes.md", "# notes")},
+		{name: "unanchored matches mid-name", expr: `\d-\d`, want: files("logs/2023-01-02.txt", "jan", "logs/2023-1-2.txt", "odd")},
+		{name: "anchored at the start only", expr: `^app/`, want: files(
+			"app/config.json", `{"debug":true}`, "app/readme.txt", "hello", "app/copy.json", `{"debug":true}`,
+		)},
+		{name: "entry with leading ./", expr: `^app-b/`, want: files("./app-b/run.sh", "#!/bin/sh\n")},
+		{name: "nothing matches", expr: `^x`, want: files()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractRegexp(archive, "fixture", regexp.MustCompile(tt.expr))
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractRegexp(%q) = %q, %v; want %q", tt.expr, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractIndex(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	tests := []struct {
+		index    int
+		want     string
+		wantName string // "" if index is out of range
+	}{
+		{index: 0, want: "", wantName: "app/"},
+		{index: 1, want: `{"debug":true}`, wantName: "app/config.json"},
+		{index: 3, want: "", wantName: "app/latest"},
+		// A hardlink is returned as stored, without its target's data.
+		{index: 4, want: "", wantName: "app/copy.json"},
+		{index: 9, want: "\x89PNG\r\n\x1a\n", wantName: "img/logo"},
+		{index: 10},
+		{index: -1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.index), func(t *testing.T) {
+			data, header, err := ExtractIndex(archive, "fixture", tt.index, quiet)
+			if tt.wantName == "" {
+				checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+				if data != nil || header != nil {
+					t.Errorf("got %q, %v alongside the error", data, header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name != tt.wantName || string(data) != tt.want {
+				t.Errorf("ExtractIndex(%d) = %q, %s; want %q, %s", tt.index, data, header.Name, tt.want, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestExtractNested(t *testing.T) {
+	inner := gzipBytes(t, tarBytes(t, fileEntry("config/app.json", `{"inner":true}`), hardlinkEntry("config/copy.json", "config/app.json")))
+	archive := fixture(t, fileEntry("readme.txt", "outer"), fileEntry("vendor/inner.tgz", string(inner)), fileEntry("plain.txt", "not a tgz"))
+	tests := []struct {
+		name        string
+		inner, file string
+		want        string
+		wantOp      string
+		wantErr     error
+		wantArchive string // ArchiveName of the expected error
+	}{
+		{name: "file", inner: "vendor/inner.tgz", file: "config/app.json", want: `{"inner":true}`},
+		{name: "leading ./", inner: "./vendor/inner.tgz", file: "./config/app.json", want: `{"inner":true}`},
+		{name: "hardlink", inner: "vendor/inner.tgz", file: "config/copy.json", want: `{"inner":true}`},
+		{name: "missing inner file", inner: "vendor/inner.tgz", file: "readme.txt",
+			wantOp: OpNotFound, wantErr: ErrFileNotFound, wantArchive: "fixture:vendor/inner.tgz"},
+		{name: "missing inner archive", inner: "vendor/nope.tgz", file: "config/app.json",
+			wantOp: OpNotFound, wantErr: ErrFileNotFound, wantArchive: "fixture"},
+		{name: "inner archive not gzip", inner: "plain.txt", file: "config/app.json",
+			wantOp: OpGzip, wantArchive: "fixture:plain.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractNested(archive, "fixture", tt.inner, tt.file, quiet)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+				var extractErr *ExtractError
+				if errors.As(err, &extractErr) && extractErr.ArchiveName != tt.wantArchive {
+					t.Errorf("ArchiveName = %q, want %q", extractErr.ArchiveName, tt.wantArchive)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		want    map[string]int
+	}{
+		{name: "unique names", entries: sampleEntries(), want: map[string]int{}},
+		{name: "same name twice", entries: []entry{fileEntry("a.txt", "1"), fileEntry("b.txt", "2"), fileEntry("a.txt", "3")}, want: map[string]int{"a.txt": 2}},
+		{name: "three times", entries: []entry{fileEntry("a.txt", "1"), fileEntry("a.txt", "2"), fileEntry("a.txt", "3")}, want: map[string]int{"a.txt": 3}},
+		{name: "leading ./", entries: []entry{fileEntry("a.txt", "1"), fileEntry("./a.txt", "2")}, want: map[string]int{"a.txt": 2}},
+		{name: "trailing slash", entries: []entry{dirEntry("dir/"), dirEntry("dir")}, want: map[string]int{"dir": 2}},
+		{name: "different types", entries: []entry{fileEntry("x", "1"), symlinkEntry("x", "y"), fileEntry("y", "2")}, want: map[string]int{"x": 2}},
+		{name: "empty archive", want: map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindDuplicates(fixture(t, tt.entries...), "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindDuplicates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressedSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive *string
+		want    int64
+	}{
+		{name: "sample", archive: fixture(t, sampleEntries()...), want: sampleSize},
+		{name: "large file", archive: fixture(t, fileEntry("big.bin", string(make([]byte, 1<<20))), fileEntry("small.txt", "small")), want: 1<<20 + 5},
+		{name: "directories only", archive: fixture(t, dirEntry("a/"), dirEntry("a/b/")), want: 0},
+		{name: "empty archive", archive: fixture(t), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecompressedSize(tt.archive, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DecompressedSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	if n, err := DecompressedSize(&corrupt, "fixture", quiet); err == nil || n != 0 {
+		t.Errorf("DecompressedSize(corrupt) = %d, %v; want 0 and an error", n, err)
+	}
+}
+
+func TestCompressedSize(t *testing.T) {
+	stream := gzipBytes(t, tarBytes(t, sampleEntries()...))
+	text := strings.Repeat("compressible ", 1000)
+	big := gzipBytes(t, tarBytes(t, fileEntry("big.txt", text)))
+	tests := []struct {
+		name    string
+		archive string
+		want    int
+	}{
+		{name: "sample", archive: base64.StdEncoding.EncodeToString(stream), want: len(stream)},
+		{name: "compressible", archive: base64.StdEncoding.EncodeToString(big), want: len(big)},
+		{name: "URL alphabet", archive: base64.URLEncoding.EncodeToString(stream), want: len(stream)},
+		{name: "unpadded", archive: base64.RawStdEncoding.EncodeToString(stream[:len(stream)-1]), want: len(stream) - 1},
+		{name: "line-wrapped", archive: wrap(base64.StdEncoding.EncodeToString(stream), 76, "\n"), want: len(stream)},
+		// The archive is only base64 decoded, so any bytes are counted.
+		{name: "not gzip", archive: base64.StdEncoding.EncodeToString([]byte("plain text")), want: 10},
+		{name: "empty", archive: "", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CompressedSize(&tt.archive)
+			if err != nil || got != tt.want {
+				t.Errorf("CompressedSize() = %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+
+	// Against DecompressedSize it gives the compression ratio.
+	archive := base64.StdEncoding.EncodeToString(big)
+	compressed, _ := CompressedSize(&archive)
+	decompressed, _ := DecompressedSize(&archive, "fixture")
+	if int64(compressed) >= decompressed {
+		t.Errorf("compressed %d bytes, decompressed %d; want a ratio above 1", compressed, decompressed)
+	}
+
+	corrupt := "!!!!" + base64.StdEncoding.EncodeToString(stream)
+	n, err := CompressedSize(&corrupt, quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	if n != 0 {
+		t.Errorf("CompressedSize(corrupt) = %d, want 0", n)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name        string
+		archive     *string
+		wantEntries int
+		wantSize    int64
+	}{
+		{name: "sample", archive: fixture(t, sampleEntries()...), wantEntries: 10, wantSize: sampleSize},
+		{name: "large file", archive: fixture(t, fileEntry("big.bin", string(make([]byte, 1<<20))), fileEntry("small.txt", "small")), wantEntries: 2, wantSize: 1<<20 + 5},
+		{name: "directories only", archive: fixture(t, dirEntry("a/"), dirEntry("a/b/")), wantEntries: 2, wantSize: 0},
+		{name: "links", archive: fixture(t, fileEntry("a", "abc"), symlinkEntry("b", "a"), hardlinkEntry("c", "a")), wantEntries: 3, wantSize: 3},
+		{name: "empty archive", archive: fixture(t), wantEntries: 0, wantSize: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, size, err := Summary(tt.archive, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries != tt.wantEntries || size != tt.wantSize {
+				t.Errorf("Summary() = %d, %d; want %d, %d", entries, size, tt.wantEntries, tt.wantSize)
+			}
+			// The size agrees with DecompressedSize.
+			if want, err := DecompressedSize(tt.archive, "fixture"); err != nil || size != want {
+				t.Errorf("DecompressedSize() = %d, %v; want %d", want, err, size)
+			}
+		})
+	}
+
+	corrupt := "!!!!"
+	entries, size, err := Summary(&corrupt, "fixture", quiet)
+	checkExtractError(t, err, OpDecode, nil)
+	if entries != 0 || size != 0 {
+		t.Errorf("Summary(corrupt) = %d, %d; want 0, 0", entries, size)
+	}
+	archive := fixture(t, sampleEntries()...)
+	_, _, err = Summary(archive, "fixture", WithMaxEntries(5), quiet)
+	checkExtractError(t, err, OpTar, ErrTooManyEntries)
+}
+
+func TestMaxSize(t *testing.T) {
+	big := strings.Repeat("x", 64)
+	archive := fixture(t, fileEntry("small.txt", "hello"), fileEntry("big.txt", big))
+	// Each call reads big.txt, so WithMaxSize(64) passes and WithMaxSize(63) fails.
+	calls := []struct {
+		name string
+		call func(opts ...Option) error
+	}{
+		{"Extract", func(opts ...Option) error {
+			data, err := Extract(archive, "fixture", "big.txt", opts...)
+			if err == nil && string(data) != big {
+				return fmt.Errorf("got %q", data)
+			}
+			return err
+		}},
+		{"ExtractAll", func(opts ...Option) error { _, err := ExtractAll(archive, "fixture", opts...); return err }},
+		{"ExtractToWriter", func(opts ...Option) error {
+			_, err := ExtractToWriter(archive, "fixture", "big.txt", ioutil.Discard, opts...)
+			return err
+		}},
+		{"ExtractToDir", func(opts ...Option) error {
+			dir := t.TempDir()
+			err := ExtractToDir(archive, "fixture", dir, opts...)
+			if data, _ := ioutil.ReadFile(filepath.Join(dir, "big.txt")); len(data) > 64 {
+				return fmt.Errorf("wrote %d bytes", len(data))
+			}
+			return err
+		}},
+		{"FS", func(opts ...Option) error { _, err := FS(archive, "fixture", opts...); return err }},
+	}
+	for _, c := range calls {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.call(WithMaxSize(64)); err != nil {
+				t.Fatalf("WithMaxSize(64): %v", err)
+			}
+			if err := c.call(); err != nil {
+				t.Fatalf("no limit: %v", err)
+			}
+			err := c.call(WithMaxSize(63), quiet)
+			checkExtractError(t, err, OpTar, ErrSizeExceeded)
+		})
+	}
+}
+
+func TestMaxTotalSize(t *testing.T) {
+	// 100 entries of 100 bytes take 100 KiB of tar headers and blocks.
+	var many []entry
+	for i := 0; i < 100; i++ {
+		many = append(many, fileEntry(fmt.Sprintf("f%03d.txt", i), strings.Repeat("x", 100)))
+	}
+	manyArchive := fixture(t, many...)
+	// The end-of-archive marker is followed by 1 MiB of zeros, which only the
+	// drain after the last entry reads.
+	trailing := base64.StdEncoding.EncodeToString(gzipBytes(t, append(tarBytes(t, fileEntry("a.txt", "alpha")), make([]byte, 1<<20)...)))
+
+	tests := []struct {
+		name    string
+		archive *string
+		list    bool // call ListFiles rather than ExtractAll
+		limit   int64
+		wantErr bool
+	}{
+		{name: "ExtractAll under limit", archive: manyArchive, limit: 1 << 20},
+		{name: "ExtractAll over limit", archive: manyArchive, limit: 64 << 10, wantErr: true},
+		{name: "ListFiles under limit", archive: manyArchive, list: true, limit: 1 << 20},
+		{name: "ListFiles over limit", archive: manyArchive, list: true, limit: 64 << 10, wantErr: true},
+		{name: "trailing data without a limit", archive: &trailing, list: true},
+		{name: "trailing data over limit", archive: &trailing, list: true, limit: 64 << 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{quiet}
+			if tt.limit > 0 {
+				opts = append(opts, WithMaxTotalSize(tt.limit))
+			}
+			var err error
+			if tt.list {
+				_, err = ListFiles(tt.archive, "fixture", opts...)
+			} else {
+				_, err = ExtractAll(tt.archive, "fixture", opts...)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			checkExtractError(t, err, OpTar, ErrSizeExceeded)
+			if !strings.Contains(err.Error(), "in total") {
+				t.Errorf("error = %v, want the total-size message", err)
+			}
+		})
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	archive := fixture(t, sampleEntries()...) // 10 entries
+	calls := []struct {
+		name string
+		call func(opts ...Option) error
+	}{
+		{"ListFiles", func(opts ...Option) error {
+			names, err := ListFiles(archive, "fixture", opts...)
+			if err == nil && len(names) != 10 {
+				return fmt.Errorf("got %d names", len(names))
+			}
+			return err
+		}},
+		{"ExtractAll", func(opts ...Option) error { _, err := ExtractAll(archive, "fixture", opts...); return err }},
+		{"ExtractToDir", func(opts ...Option) error { return ExtractToDir(archive, "fixture", t.TempDir(), opts...) }},
+		{"FS", func(opts ...Option) error { _, err := FS(archive, "fixture", opts...); return err }},
+		// img/logo is the last entry, so the whole archive is read to find it.
+		{"Extract", func(opts ...Option) error { _, err := Extract(archive, "fixture", "img/logo", opts...); return err }},
+	}
+	for _, c := range calls {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.call(WithMaxEntries(10)); err != nil {
+				t.Fatalf("WithMaxEntries(10): %v", err)
+			}
+			if err := c.call(WithMaxEntries(0)); err != nil {
+				t.Fatalf("WithMaxEntries(0): %v", err)
+			}
+			err := c.call(WithMaxEntries(9), quiet)
+			checkExtractError(t, err, OpTar, ErrTooManyEntries)
+		})
+	}
+
+	// An entry found before the limit is reached is returned.
+	if data, err := Extract(archive, "fixture", "app/readme.txt", WithMaxEntries(3)); err != nil || string(data) != "hello" {
+		t.Errorf("Extract() before the limit = %q, %v", data, err)
+	}
+
+	// Many tiny entries stop the walk early rather than filling the index.
+	var tiny []entry
+	for i := 0; i < 1000; i++ {
+		tiny = append(tiny, fileEntry(fmt.Sprintf("f%04d", i), ""))
+	}
+	_, err := ExtractAll(fixture(t, tiny...), "fixture", WithMaxEntries(100), quiet)
+	checkExtractError(t, err, OpTar, ErrTooManyEntries)
+	if !strings.Contains(err.Error(), "over 100 entries") {
+		t.Errorf("error = %q, want the limit in the message", err)
+	}
+
+	// ExtractWithOptions takes the limit as a field.
+	_, err = ExtractWithOptions(archive, Options{ArchiveName: "fixture", FilePath: "img/logo", MaxEntries: 9, Quiet: true})
+	checkExtractError(t, err, OpTar, ErrTooManyEntries)
+}
@@ -1,5 +1,9 @@
 module github.com/casnix/go-selftgz
 
-go 1.13
+go 1.16
 
-require github.com/TwiN/go-color v1.1.0 // indirect
+require (
+	github.com/TwiN/go-color v1.1.0
+	github.com/klauspost/compress v1.13.6
+	github.com/ulikunitz/xz v0.5.11
+)
@@ -0,0 +1,79 @@
+/*****************************************************************/
+/* log.go -- Log output for go-selftgz.                          */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/TwiN/go-color"
+)
+
+// Logger receives the library's log output.  *log.Logger satisfies it, as
+// does any structured logger with a Printf method.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// stdLogger is the default Logger; it writes through the standard log package.
+type stdLogger struct{}
+
+// Printf(string, ...interface{}) -- Writes a log line with log.Printf
+func (stdLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// logf(string, ...interface{}) -- Writes a log line to cfg.logger unless quiet
+func (cfg *config) logf(format string, args ...interface{}) {
+	if cfg.quiet {
+		return
+	}
+	cfg.logger.Printf(format, args...)
+}
+
+// debugf(string, ...interface{}) -- Writes a log line only when debug output is enabled
+func (cfg *config) debugf(format string, args ...interface{}) {
+	if !cfg.debug {
+		return
+	}
+	cfg.logf(format, args...)
+}
+
+// paint(string, string) -- Wraps s in an ANSI color when color output is enabled
+func (cfg *config) paint(c, s string) string {
+	if !cfg.color {
+		return s
+	}
+	return color.Ize(c, s)
+}
+
+// isTerminal(io.Writer) -- Reports whether w is a character device such as a TTY
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
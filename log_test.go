@@ -0,0 +1,218 @@
+/*****************************************************************/
+/* log_test.go -- Tests the log output of base64 TGZ             */
+/* extraction.                                                   */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// recordingLogger keeps every line logged through it.
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// captureLog(*testing.T) -- Redirects the standard log package into the returned buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestLogger(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "alpha"))
+	corrupt := "!!!!" + *archive
+	tests := []struct {
+		name    string
+		archive *string
+		opts    []Option
+		want    []string // prefixes, one per expected line
+	}{
+		{name: "nothing on success", archive: archive},
+		{name: "error", archive: &corrupt, want: []string{DefaultLogName + " ERROR -- CANNOT DECODE fixture!!!"}},
+		{name: "WithLogName", archive: &corrupt, opts: []Option{WithLogName("[assets]")}, want: []string{"[assets] ERROR -- "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			std := captureLog(t)
+			logger := &recordingLogger{}
+			Extract(tt.archive, "fixture", "a.txt", append([]Option{WithLogger(logger)}, tt.opts...)...)
+			if len(logger.lines) != len(tt.want) {
+				t.Fatalf("logged %q, want %d lines", logger.lines, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(logger.lines[i], want) {
+					t.Errorf("line %d = %q, want it to start %q", i, logger.lines[i], want)
+				}
+			}
+			if std.Len() != 0 {
+				t.Errorf("standard log got %q, want nothing with a custom Logger", std)
+			}
+
+			// Without WithLogger, the same lines go to the standard log package.
+			Extract(tt.archive, "fixture", "a.txt", tt.opts...)
+			if got := strings.Count(std.String(), "\n"); got != len(tt.want) {
+				t.Errorf("standard log got %q, want %d lines", std, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSilent(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "alpha"))
+	corrupt := "!!!!" + *archive
+	// Each call fails on the corrupt archive; a WithLogger and a WithSilent
+	// option are appended to opts.
+	calls := []struct {
+		name string
+		call func(archive *string, opts ...Option) error
+	}{
+		{"Extract", func(a *string, opts ...Option) error { _, err := Extract(a, "fixture", "a.txt", opts...); return err }},
+		{"ExtractFileString", func(a *string, opts ...Option) error {
+			_, err := ExtractFileString(a, "fixture", "a.txt", opts...)
+			return err
+		}},
+		{"ExtractFileOrDefault", func(a *string, opts ...Option) error {
+			if got := ExtractFileOrDefault(a, "fixture", "a.txt", []byte("def"), opts...); string(got) != "def" {
+				return fmt.Errorf("got %q", got)
+			}
+			return errors.New("defaulted")
+		}},
+		{"ExtractFileZstd", func(a *string, opts ...Option) error {
+			_, err := ExtractFileZstd(a, "fixture", "a.txt", opts...)
+			return err
+		}},
+		{"ExtractFileReader", func(a *string, opts ...Option) error {
+			_, err := ExtractFileReader(strings.NewReader(*a), "fixture", "a.txt", opts...)
+			return err
+		}},
+		{"StatFile", func(a *string, opts ...Option) error { _, err := StatFile(a, "fixture", "a.txt", opts...); return err }},
+		{"ListEntries", func(a *string, opts ...Option) error { _, err := ListEntries(a, "fixture", opts...); return err }},
+		{"ExtractGlob", func(a *string, opts ...Option) error { _, err := ExtractGlob(a, "fixture", "*", opts...); return err }},
+		{"WalkFiles", func(a *string, opts ...Option) error {
+			return WalkFiles(a, "fixture", func(*tar.Header, io.Reader) error { return nil }, opts...)
+		}},
+		{"Validate", func(a *string, opts ...Option) error { return Validate(a, "fixture", opts...) }},
+		{"NewArchive", func(a *string, opts ...Option) error { _, err := NewArchive(a, "fixture", opts...); return err }},
+		{"NewIterator", func(a *string, opts ...Option) error { _, err := NewIterator(a, "fixture", opts...); return err }},
+		{"Recompress", func(a *string, opts ...Option) error {
+			_, err := Recompress(a, CompressionGzip, CompressionZstd, opts...)
+			return err
+		}},
+	}
+	for _, c := range calls {
+		t.Run(c.name, func(t *testing.T) {
+			std := captureLog(t)
+			logger := &recordingLogger{}
+			loudErr := c.call(&corrupt, WithLogger(logger))
+			if len(logger.lines) == 0 {
+				t.Fatal("nothing logged without WithSilent")
+			}
+			logger.lines = nil
+			quietErr := c.call(&corrupt, WithLogger(logger), WithSilent())
+			if len(logger.lines) != 0 || std.Len() != 0 {
+				t.Errorf("logged %q and %q with WithSilent, want nothing", logger.lines, std)
+			}
+			if loudErr == nil || fmt.Sprint(quietErr) != fmt.Sprint(loudErr) {
+				t.Errorf("error = %v with WithSilent, want %v as without", quietErr, loudErr)
+			}
+		})
+	}
+}
+
+func TestDebug(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "alpha"))
+	tests := []struct {
+		name string
+		opts []Option
+		want []string // prefixes, one per expected line
+	}{
+		{name: "end of archive not logged by default"},
+		{name: "WithDebug", opts: []Option{WithDebug()}, want: []string{DefaultLogName + " Reached end of fixture tarball read."}},
+		{name: "WithDebug and WithSilent", opts: []Option{WithDebug(), WithSilent()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			_, err := Extract(archive, "fixture", "missing", append([]Option{WithLogger(logger)}, tt.opts...)...)
+			checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+			if len(logger.lines) != len(tt.want) {
+				t.Fatalf("logged %q, want %d lines", logger.lines, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(logger.lines[i], want) {
+					t.Errorf("line %d = %q, want it to start %q", i, logger.lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestColor(t *testing.T) {
+	archive := fixture(t, fileEntry("a.txt", "alpha"))
+	corrupt := "!!!!" + *archive
+	tests := []struct {
+		name      string
+		custom    bool // log through a recordingLogger instead of the standard log
+		opts      []Option
+		wantColor bool
+	}{
+		{name: "standard log redirected to a buffer"},
+		{name: "custom logger", custom: true},
+		{name: "WithColor(true)", opts: []Option{WithColor(true)}, wantColor: true},
+		{name: "WithColor(true) and a custom logger", custom: true, opts: []Option{WithColor(true)}, wantColor: true},
+		{name: "WithColor(false)", opts: []Option{WithColor(false)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			std := captureLog(t)
+			logger := &recordingLogger{}
+			opts := tt.opts
+			if tt.custom {
+				opts = append([]Option{WithLogger(logger)}, opts...)
+			}
+			Extract(&corrupt, "fixture", "a.txt", opts...)
+			out := std.String() + strings.Join(logger.lines, "\n")
+			if !strings.Contains(out, "ERROR") {
+				t.Fatalf("logged %q, want an ERROR line", out)
+			}
+			if got := strings.Contains(out, "\x1b["); got != tt.wantColor {
+				t.Errorf("logged %q, escape sequences = %v, want %v", out, got, tt.wantColor)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+/*****************************************************************/
+/* options.go -- Options for extracting files from a base64      */
+/* TGZ archive.                                                  */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"encoding/base64"
+)
+
+// Options configures ExtractWithOptions.  Unset fields take their defaults.
+type Options struct {
+	ArchiveName  string // archive name used in logs and errors
+	FilePath     string // path of the file within the archive
+	LogName      string // log prefix, default = DefaultLogName
+	MaxSize      int64  // largest file size in bytes to extract, 0 = unlimited; see ErrSizeExceeded
+	MaxTotalSize int64  // most decompressed bytes to read across the whole walk, 0 = unlimited
+	MaxEntries   int    // most tar entries to read, 0 = unlimited; see ErrTooManyEntries
+	Logger       Logger // log destination, default = the standard log package
+	Quiet        bool   // suppress all log output
+	Debug        bool   // also log routine events such as reaching the end of the archive
+	ValidUTF8    bool   // fail with ErrNotUTF8 unless extracted data is valid UTF-8
+	SingleStream bool   // read only the first member of a concatenated (multistream) gzip archive
+	Color        *bool  // force ANSI color on or off, nil = only when logging to a terminal
+
+	// Encoding is the base64 alphabet of the archive, padded or not.  If nil
+	// the standard alphabet is tried first, then the URL-safe one.
+	Encoding *base64.Encoding
+	// Strict rejects base64 that is not in canonical form: line breaks,
+	// nonzero padding bits, or characters left over after the decoded data.
+	Strict bool
+	// IgnoreWhitespace drops spaces, tabs, and line breaks before decoding,
+	// as in wrapped `base64 file` output.  nil = on, unless Strict is set.
+	IgnoreWhitespace *bool
+
+	// ExtractToDir settings
+	StripPrefix       string                     // leading directory to remove from entry names
+	Filter            func(hdr *tar.Header) bool // entries for which Filter returns false are skipped
+	PreserveModTime   bool                       // set each file's times from its tar header
+	PreserveMode      bool                       // apply exact permission bits, ignoring the umask
+	WriterFS          WriterFS                   // filesystem to extract into, nil for the OS filesystem
+	PreserveOwnership bool                       // chown files and directories to their tar uid and gid, where permitted
+
+	// ExtractToDir and ExtractToWriter settings
+	Progress   func(bytesDone, bytesTotal int64) // called as file data is copied
+	BufferSize *int                              // copy buffer size in bytes, nil = io.Copy's default; must be positive
+
+	// ExtractFileLayered settings
+	FirstMatch bool // take the file from the first archive that holds it, not the last
+}
+
+// Option sets a field of Options; pass any number of them to Extract.
+type Option func(*Options)
+
+// WithLogName(string) -- Sets the log prefix
+func WithLogName(logName string) Option {
+	return func(opts *Options) {
+		opts.LogName = logName
+	}
+}
+
+// WithMaxSize(int64) -- Fails with ErrSizeExceeded on files larger than maxSize bytes; 0 = unlimited
+func WithMaxSize(maxSize int64) Option {
+	return func(opts *Options) {
+		opts.MaxSize = maxSize
+	}
+}
+
+// WithMaxEntries(int) -- Fails with ErrTooManyEntries once the walk reaches more than maxEntries entries
+func WithMaxEntries(maxEntries int) Option {
+	return func(opts *Options) {
+		opts.MaxEntries = maxEntries
+	}
+}
+
+// WithMaxTotalSize(int64) -- Fails with ErrSizeExceeded once the walk reads over maxTotal decompressed bytes
+func WithMaxTotalSize(maxTotal int64) Option {
+	return func(opts *Options) {
+		opts.MaxTotalSize = maxTotal
+	}
+}
+
+// WithLogger(Logger) -- Sends log output to logger instead of the standard log package
+func WithLogger(logger Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+// WithSilent() -- Suppresses all log output; data and errors are unaffected
+func WithSilent() Option {
+	return func(opts *Options) {
+		opts.Quiet = true
+	}
+}
+
+// WithDebug() -- Enables debug-level log lines
+func WithDebug() Option {
+	return func(opts *Options) {
+		opts.Debug = true
+	}
+}
+
+// WithColor(bool) -- Forces ANSI color in log output on or off
+func WithColor(enabled bool) Option {
+	return func(opts *Options) {
+		opts.Color = &enabled
+	}
+}
+
+// WithStripPrefix(string) -- Makes ExtractToDir drop prefix from entry names, skipping entries outside it
+func WithStripPrefix(prefix string) Option {
+	return func(opts *Options) {
+		opts.StripPrefix = prefix
+	}
+}
+
+// WithFilter(func(*tar.Header) bool) -- Makes ExtractToDir write only entries for which keep returns true
+func WithFilter(keep func(hdr *tar.Header) bool) Option {
+	return func(opts *Options) {
+		opts.Filter = keep
+	}
+}
+
+// WithPreserveModTime() -- Makes ExtractToDir restore file modification times
+func WithPreserveModTime() Option {
+	return func(opts *Options) {
+		opts.PreserveModTime = true
+	}
+}
+
+// WithPreserveMode() -- Makes ExtractToDir apply exact permission bits regardless of the umask
+func WithPreserveMode() Option {
+	return func(opts *Options) {
+		opts.PreserveMode = true
+	}
+}
+
+// WithPreserveOwnership() -- Makes ExtractToDir chown entries to their tar uid and gid, ignoring permission errors
+func WithPreserveOwnership() Option {
+	return func(opts *Options) {
+		opts.PreserveOwnership = true
+	}
+}
+
+// WithWriterFS(WriterFS) -- Makes ExtractToDir write through fsys instead of the OS filesystem
+func WithWriterFS(fsys WriterFS) Option {
+	return func(opts *Options) {
+		opts.WriterFS = fsys
+	}
+}
+
+// WithProgress(func(int64, int64)) -- Makes ExtractToDir and ExtractToWriter report bytes copied so far
+func WithProgress(progress func(bytesDone, bytesTotal int64)) Option {
+	return func(opts *Options) {
+		opts.Progress = progress
+	}
+}
+
+// WithBufferSize(int) -- Makes ExtractToDir and ExtractToWriter copy file data through an n-byte buffer; n must be positive
+func WithBufferSize(n int) Option {
+	return func(opts *Options) {
+		opts.BufferSize = &n
+	}
+}
+
+// WithFirstMatch() -- Makes ExtractFileLayered prefer earlier archives, so the base shadows its overlays
+func WithFirstMatch() Option {
+	return func(opts *Options) {
+		opts.FirstMatch = true
+	}
+}
+
+// WithEncoding(*base64.Encoding) -- Decodes the archive with enc only, padded or not, e.g. a custom alphabet
+func WithEncoding(enc *base64.Encoding) Option {
+	return func(opts *Options) {
+		opts.Encoding = enc
+	}
+}
+
+// WithSingleStream() -- Stops reading a gzip archive at the end of its first member
+func WithSingleStream() Option {
+	return func(opts *Options) {
+		opts.SingleStream = true
+	}
+}
+
+// WithStrict() -- Rejects archives whose base64 is not canonical, e.g. two concatenated constants
+func WithStrict() Option {
+	return func(opts *Options) {
+		opts.Strict = true
+	}
+}
+
+// WithIgnoreWhitespace(bool) -- Turns skipping of ASCII whitespace in the base64 on or off; on by default
+func WithIgnoreWhitespace(enabled bool) Option {
+	return func(opts *Options) {
+		opts.IgnoreWhitespace = &enabled
+	}
+}
+
+// WithValidUTF8() -- Fails with ErrNotUTF8 if an extracted file is not valid UTF-8
+func WithValidUTF8() Option {
+	return func(opts *Options) {
+		opts.ValidUTF8 = true
+	}
+}
+
+// WithURLEncoding() -- Decodes the archive with the URL-safe base64 alphabet only
+func WithURLEncoding() Option {
+	return func(opts *Options) {
+		opts.Encoding = base64.URLEncoding
+	}
+}
+
+// ExtractWithOptions(*string, Options) -- Extracts file from a base64 TGZ archive in a string.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        opts         Options		-- archive name, file path, and settings
+// Output:
+//         []byte		-- File data
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if opts.FilePath is not in the archive
+func ExtractWithOptions(archive *string, opts Options) ([]byte, error) {
+	fileData, _, err := extractFile(archive, opts.ArchiveName, opts.FilePath, opts.config())
+	return fileData, err
+}
+
+// optionsConfig([]Option) -- Applies opts to zero Options and returns the walker settings
+func optionsConfig(opts []Option) *config {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options.config()
+}
+
+// config() -- Converts Options to the internal walker settings
+func (opts Options) config() *config {
+	cfg := defaultConfig()
+	if opts.LogName != "" {
+		cfg.logName = opts.LogName
+	}
+	cfg.maxSize = opts.MaxSize
+	cfg.maxTotal = opts.MaxTotalSize
+	cfg.maxEntries = opts.MaxEntries
+	cfg.validUTF8 = opts.ValidUTF8
+	cfg.singleStream = opts.SingleStream
+	if opts.Logger != nil {
+		cfg.logger = opts.Logger
+		// The destination of a custom logger is unknown, so assume it is not a terminal.
+		cfg.color = false
+	}
+	if opts.Color != nil {
+		cfg.color = *opts.Color
+	}
+	cfg.stripPrefix = opts.StripPrefix
+	cfg.filter = opts.Filter
+	cfg.preserveModTime = opts.PreserveModTime
+	cfg.preserveMode = opts.PreserveMode
+	cfg.preserveOwner = opts.PreserveOwnership
+	cfg.writerFS = opts.WriterFS
+	cfg.progress = opts.Progress
+	if opts.BufferSize != nil {
+		n := *opts.BufferSize
+		cfg.bufferSize = &n
+	}
+	cfg.firstMatch = opts.FirstMatch
+	cfg.quiet = opts.Quiet
+	cfg.strict = opts.Strict
+	if opts.IgnoreWhitespace != nil {
+		cfg.ignoreWhitespace = *opts.IgnoreWhitespace
+	}
+	cfg.debug = opts.Debug
+	if opts.Encoding != nil {
+		cfg.encodings = []*base64.Encoding{opts.Encoding, opts.Encoding.WithPadding(base64.NoPadding)}
+	}
+	return cfg
+}
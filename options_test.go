@@ -0,0 +1,366 @@
+/*****************************************************************/
+/* options_test.go -- Tests the functional options and logging.  */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractWithOptions(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	corrupt := "!!!!" + *archive
+	tests := []struct {
+		name    string
+		archive *string
+		opts    Options
+		want    string
+		wantOp  string // Op of the expected *ExtractError, "" for success
+		wantErr error  // wrapped by the expected error, if set
+		wantLog string // prefix of the one expected log line, "" for none
+	}{
+		{name: "defaults", archive: archive, opts: Options{ArchiveName: "fixture", FilePath: "app/readme.txt"}, want: "hello"},
+		{name: "default log name", archive: &corrupt, opts: Options{ArchiveName: "fixture", FilePath: "app/readme.txt"}, wantOp: OpDecode, wantLog: DefaultLogName + " ERROR"},
+		{name: "zero value", archive: archive, wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{
+			name:    "fully specified",
+			archive: archive,
+			opts:    Options{ArchiveName: "fixture", FilePath: "./app/config.json", LogName: "[assets]", MaxSize: 14},
+			want:    `{"debug":true}`,
+		},
+		{
+			name:    "fully specified, over MaxSize",
+			archive: archive,
+			opts:    Options{ArchiveName: "fixture", FilePath: "./app/config.json", LogName: "[assets]", MaxSize: 13},
+			wantOp:  OpTar,
+			wantErr: ErrSizeExceeded,
+		},
+		{
+			name:    "fully specified, corrupt",
+			archive: &corrupt,
+			opts:    Options{ArchiveName: "fixture", FilePath: "./app/config.json", LogName: "[assets]", MaxSize: 14},
+			wantOp:  OpDecode,
+			wantLog: "[assets] ERROR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			tt.opts.Logger = logger
+			got, err := ExtractWithOptions(tt.archive, tt.opts)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+				if extractErr := err.(*ExtractError); extractErr.ArchiveName != tt.opts.ArchiveName {
+					t.Errorf("ArchiveName = %q, want %q", extractErr.ArchiveName, tt.opts.ArchiveName)
+				}
+			} else if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractWithOptions() = %q, %v; want %q", got, err, tt.want)
+			}
+			if tt.wantLog == "" && len(logger.lines) != 0 || tt.wantLog != "" && (len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], tt.wantLog)) {
+				t.Errorf("logged %q, want one line starting %q", logger.lines, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestFunctionalOptions(t *testing.T) {
+	raw := gzipBytes(t, tarBytes(t, fileEntry("a.txt", "alpha"), fileEntry("big.txt", strings.Repeat("b", 100))))
+	std := base64.StdEncoding.EncodeToString(raw)
+	url := base64.URLEncoding.EncodeToString(raw)
+	corrupt := "!!!!" + std
+	tests := []struct {
+		name    string
+		archive string
+		file    string
+		opts    func(Logger) []Option
+		want    string
+		wantOp  string // Op of the expected *ExtractError, "" for success
+		wantErr error  // wrapped by the expected error, if set
+		wantLog string // prefix of the one expected log line, "" for none
+	}{
+		{
+			name: "log name, logger and max size", archive: std, file: "a.txt", want: "alpha",
+			opts: func(l Logger) []Option { return []Option{WithLogName("[assets]"), WithLogger(l), WithMaxSize(5)} },
+		},
+		{
+			name: "log name, logger and max size, over the limit", archive: std, file: "big.txt", wantOp: OpTar, wantErr: ErrSizeExceeded,
+			opts: func(l Logger) []Option { return []Option{WithLogName("[assets]"), WithLogger(l), WithMaxSize(99)} },
+		},
+		{
+			name: "log name and logger on error", archive: corrupt, file: "a.txt", wantOp: OpDecode, wantLog: "[assets] ERROR",
+			opts: func(l Logger) []Option { return []Option{WithLogName("[assets]"), WithLogger(l)} },
+		},
+		{
+			name: "URL encoding and max size", archive: url, file: "big.txt", want: strings.Repeat("b", 100),
+			opts: func(l Logger) []Option { return []Option{WithURLEncoding(), WithMaxSize(100), WithLogger(l)} },
+		},
+		{
+			name: "URL encoding only", archive: std, file: "a.txt", wantOp: OpDecode, wantLog: DefaultLogName + " ERROR",
+			opts: func(l Logger) []Option { return []Option{WithURLEncoding(), WithLogger(l)} },
+		},
+		{
+			name: "later options win", archive: std, file: "big.txt", want: strings.Repeat("b", 100),
+			opts: func(l Logger) []Option { return []Option{WithMaxSize(1), WithLogger(l), WithMaxSize(0)} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			got, err := Extract(&tt.archive, "fixture", tt.file, tt.opts(logger)...)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, tt.wantErr)
+			} else if err != nil || string(got) != tt.want {
+				t.Errorf("Extract() = %q, %v; want %q", got, err, tt.want)
+			}
+			if tt.wantLog == "" && len(logger.lines) != 0 || tt.wantLog != "" && (len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], tt.wantLog)) {
+				t.Errorf("logged %q, want one line starting %q", logger.lines, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestWithEncoding(t *testing.T) {
+	packed, err := PackFiles(files("a.txt", "alpha", "b/c.txt", "gamma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := base64.NewEncoding("ZYXWVUTSRQPONMLKJIHGFEDCBAzyxwvutsrqponmlkjihgfedcba9876543210+/")
+	tests := []struct {
+		name    string
+		archive string
+		enc     *base64.Encoding
+		wantOp  string
+	}{
+		{name: "RawStdEncoding", archive: base64.RawStdEncoding.EncodeToString(raw), enc: base64.RawStdEncoding},
+		{name: "RawURLEncoding", archive: base64.RawURLEncoding.EncodeToString(raw), enc: base64.RawURLEncoding},
+		{name: "custom alphabet", archive: custom.EncodeToString(raw), enc: custom},
+		{name: "custom alphabet unpadded", archive: strings.TrimRight(custom.EncodeToString(raw), "="), enc: custom},
+		{name: "custom alphabet wrapped", archive: wrap(custom.EncodeToString(raw), 76, "\n"), enc: custom},
+		// Only the given alphabet is tried.  The two alphabets share their
+		// characters, so the mismatch only shows once the bytes are gunzipped.
+		{name: "standard archive", archive: packed, enc: custom, wantOp: OpGzip},
+		{name: "custom archive without WithEncoding", archive: custom.EncodeToString(raw), wantOp: OpGzip},
+		{name: "URL-safe characters", archive: base64.RawURLEncoding.EncodeToString(raw) + "-_", enc: base64.RawStdEncoding, wantOp: OpDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{quiet}
+			if tt.enc != nil {
+				opts = append(opts, WithEncoding(tt.enc))
+			}
+			got, err := ExtractAll(&tt.archive, "fixture", opts...)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := files("a.txt", "alpha", "b/c.txt", "gamma"); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if n, err := CompressedSize(&tt.archive, opts...); err != nil || n != len(raw) {
+				t.Errorf("CompressedSize() = %d, %v; want %d", n, err, len(raw))
+			}
+		})
+	}
+}
+
+func TestWithStrict(t *testing.T) {
+	std := *fixture(t, fileEntry("a.txt", "alpha"))
+	end := fmt.Sprintf("at input byte %d", len(std))
+	tests := []struct {
+		name    string
+		archive string
+		strict  bool
+		wantErr string // substring of the expected error, "" for success
+	}{
+		{name: "canonical", archive: std, strict: true},
+		{name: "trailing newline", archive: std + "\n", strict: true, wantErr: "line break " + end},
+		{name: "trailing newline without WithStrict", archive: std + "\n"},
+		{name: "trailing space", archive: std + " ", strict: true, wantErr: "illegal base64 data " + end},
+		{name: "trailing space without WithStrict", archive: std + " "},
+		{name: "trailing garbage", archive: std + "!!", strict: true, wantErr: "illegal base64 data " + end},
+		{name: "trailing garbage without WithStrict", archive: std + "!!", wantErr: "illegal base64 data " + end},
+		{name: "two archives", archive: std + std, strict: true, wantErr: "illegal base64 data " + end},
+		{name: "line breaks", archive: wrap(std, 76, "\n"), strict: true, wantErr: "line break at input byte 76"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{quiet}
+			if tt.strict {
+				opts = append(opts, WithStrict())
+			}
+			got, err := Extract(&tt.archive, "fixture", "a.txt", opts...)
+			if tt.wantErr == "" {
+				if err != nil || string(got) != "alpha" {
+					t.Errorf("Extract() = %q, %v; want alpha", got, err)
+				}
+				return
+			}
+			checkExtractError(t, err, OpDecode, nil)
+			if !strings.Contains(fmt.Sprint(err), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithIgnoreWhitespace(t *testing.T) {
+	std := *fixture(t, fileEntry("a.txt", "alpha"), fileEntry("b.txt", "beta"))
+	tests := []struct {
+		name    string
+		archive string
+		ignore  *bool // nil = the default
+		wantOp  string
+	}{
+		{name: "wrapped at 76 columns", archive: wrap(std, 76, "\n") + "\n"},
+		{name: "CRLF line breaks", archive: wrap(std, 64, "\r\n")},
+		{name: "spaces and tabs", archive: wrap(std, 10, " \t")},
+		{name: "indented Go string", archive: "\n\t\t" + wrap(std, 60, "\n\t\t") + "\n\t"},
+		{name: "line breaks when off", archive: wrap(std, 76, "\n"), ignore: new(bool)},
+		{name: "spaces when off", archive: wrap(std, 10, " "), ignore: new(bool), wantOp: OpDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{quiet}
+			if tt.ignore != nil {
+				opts = append(opts, WithIgnoreWhitespace(*tt.ignore))
+			}
+			got, err := Extract(&tt.archive, "fixture", "b.txt", opts...)
+			if tt.wantOp != "" {
+				checkExtractError(t, err, tt.wantOp, nil)
+				return
+			}
+			if err != nil || string(got) != "beta" {
+				t.Fatalf("Extract() = %q, %v; want beta", got, err)
+			}
+			if names, err := ListFiles(&tt.archive, "fixture", opts...); err != nil || !equalStrings(names, []string{"a.txt", "b.txt"}) {
+				t.Errorf("ListFiles() = %q, %v", names, err)
+			}
+		})
+	}
+}
+
+func TestWithValidUTF8(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{name: "ASCII", body: "alpha", valid: true},
+		{name: "multibyte", body: "héllo, 世界", valid: true},
+		{name: "empty", body: "", valid: true},
+		{name: "PNG header", body: "\x89PNG"},
+		{name: "truncated multibyte", body: "世界"[:4]},
+		{name: "UTF-16 byte order mark", body: "\xff\xfea\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := fixture(t, fileEntry("other.txt", "other"), fileEntry("file", tt.body))
+			got, err := Extract(archive, "fixture", "file", WithValidUTF8(), quiet)
+			if tt.valid {
+				if err != nil || string(got) != tt.body {
+					t.Errorf("Extract() = %q, %v; want %q", got, err, tt.body)
+				}
+			} else {
+				checkExtractError(t, err, OpVerify, ErrNotUTF8)
+				if _, err := ExtractAll(archive, "fixture", WithValidUTF8(), quiet); !errors.Is(err, ErrNotUTF8) {
+					t.Errorf("ExtractAll() error = %v, want ErrNotUTF8", err)
+				}
+			}
+			// Without the option the body is returned as is.
+			if got, err := Extract(archive, "fixture", "file"); err != nil || string(got) != tt.body {
+				t.Errorf("Extract() without WithValidUTF8 = %q, %v; want %q", got, err, tt.body)
+			}
+		})
+	}
+}
+
+func TestWithSingleStream(t *testing.T) {
+	// Gzip members each holding part of one tar stream, the way two archive
+	// constants read when pasted together: every member but the last lacks
+	// the end-of-archive marker.
+	member := func(e ...entry) []byte {
+		b := tarBytes(t, e...)
+		return gzipBytes(t, b[:len(b)-1024])
+	}
+	last := func(e ...entry) []byte { return gzipBytes(t, tarBytes(t, e...)) }
+	concat := func(members ...[]byte) string {
+		var b []byte
+		for _, m := range members {
+			b = append(b, m...)
+		}
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name    string
+		archive string
+		single  []string
+		multi   []string
+	}{
+		{
+			name:    "one member",
+			archive: concat(last(fileEntry("a.txt", "alpha"))),
+			single:  []string{"a.txt"},
+			multi:   []string{"a.txt"},
+		},
+		{
+			name:    "two members",
+			archive: concat(member(fileEntry("a.txt", "alpha")), last(fileEntry("b.txt", "beta"))),
+			single:  []string{"a.txt"},
+			multi:   []string{"a.txt", "b.txt"},
+		},
+		{
+			name:    "three members",
+			archive: concat(member(fileEntry("a.txt", "alpha")), member(fileEntry("b.txt", "beta")), last(fileEntry("c.txt", "gamma"))),
+			single:  []string{"a.txt"},
+			multi:   []string{"a.txt", "b.txt", "c.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ListFiles(&tt.archive, "fixture", quiet); err != nil || !equalStrings(got, tt.multi) {
+				t.Errorf("ListFiles() = %q, %v; want %q", got, err, tt.multi)
+			}
+			if got, err := ListFiles(&tt.archive, "fixture", WithSingleStream(), quiet); err != nil || !equalStrings(got, tt.single) {
+				t.Errorf("ListFiles(WithSingleStream) = %q, %v; want %q", got, err, tt.single)
+			}
+			name := tt.multi[len(tt.multi)-1]
+			got, err := Extract(&tt.archive, "fixture", name, quiet)
+			if err != nil || len(got) == 0 {
+				t.Errorf("Extract(%q) = %q, %v", name, got, err)
+			}
+			if len(tt.multi) > 1 {
+				_, err := Extract(&tt.archive, "fixture", name, WithSingleStream(), quiet)
+				checkExtractError(t, err, OpNotFound, nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+/*****************************************************************/
+/* pack.go -- Builds the base64 TGZ strings that go-selftgz      */
+/* extracts, ready to paste into Go source.                      */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PackDir(string) -- Packs a directory tree into a base64 TGZ string.
+// Entry names are relative to srcDir with forward slashes.  Directories and
+// regular files are stored with their permission bits; other file types
+// are skipped.
+// Input:
+//        srcDir       string		-- directory to pack
+// Output:
+//         string		-- base64 TGZ archive
+//         err			-- Present only if error is encountered
+func PackDir(srcDir string) (string, error) {
+	return PackDirLevel(srcDir, gzip.DefaultCompression)
+}
+
+// PackDirLevel(string, int) -- PackDir with a chosen gzip compression level.
+// Input:
+//        srcDir       string		-- directory to pack
+//        level        int		-- gzip.BestSpeed through gzip.BestCompression,
+//                                 or gzip.DefaultCompression
+// Output:
+//         string		-- base64 TGZ archive
+//         err			-- Present only if error is encountered, including an
+//                         out-of-range level
+func PackDirLevel(srcDir string, level int) (string, error) {
+	var buf bytes.Buffer
+	gz, err := newPackWriter(&buf, level)
+	if err != nil {
+		return "", err
+	}
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(srcDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return copyFile(tw, file)
+	})
+	if err != nil {
+		return "", err
+	}
+	return finishPack(&buf, gz, tw)
+}
+
+// PackFiles(map[string][]byte) -- Packs in-memory files into a base64 TGZ string.
+// Entries are written in sorted name order as 0644 regular files with a
+// fixed modification time, so equal maps always produce equal strings.
+// Input:
+//        entries      map[string][]byte	-- file contents keyed by entry name
+// Output:
+//         string		-- base64 TGZ archive
+//         err			-- Present only if error is encountered
+func PackFiles(entries map[string][]byte) (string, error) {
+	return PackFilesLevel(entries, gzip.DefaultCompression)
+}
+
+// PackFilesLevel(map[string][]byte, int) -- PackFiles with a chosen gzip compression level.
+// Input:
+//        entries      map[string][]byte	-- file contents keyed by entry name
+//        level        int		-- gzip.BestSpeed through gzip.BestCompression,
+//                                 or gzip.DefaultCompression
+// Output:
+//         string		-- base64 TGZ archive
+//         err			-- Present only if error is encountered, including an
+//                         out-of-range level
+func PackFilesLevel(entries map[string][]byte, level int) (string, error) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz, err := newPackWriter(&buf, level)
+	if err != nil {
+		return "", err
+	}
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(entries[name])),
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(entries[name]); err != nil {
+			return "", err
+		}
+	}
+	return finishPack(&buf, gz, tw)
+}
+
+// newPackWriter(io.Writer, int) -- Returns a gzip writer at level, rejecting levels other than the standard ones
+func newPackWriter(w io.Writer, level int) (*gzip.Writer, error) {
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return nil, fmt.Errorf("gzip level %d out of range %d to %d", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// copyFile(io.Writer, string) -- Copies the contents of a file on disk to w
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// finishPack(*bytes.Buffer, *gzip.Writer, *tar.Writer) -- Flushes the writers and base64-encodes the archive
+// Input:
+//         buf         *bytes.Buffer	-- destination of gz
+//         gz          *gzip.Writer	-- destination of tw
+//         tw          *tar.Writer	-- archive being built
+// Output:
+//         string		-- base64 TGZ archive
+//         err          error		-- set if either writer fails to close
+func finishPack(buf *bytes.Buffer, gz *gzip.Writer, tw *tar.Writer) (string, error) {
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Recompress(*string, Compression, Compression, ...Option) -- Converts a base64 tar archive to another compression format.
+// The decompressed tar stream is copied through unchanged, so every entry
+// and header is preserved byte for byte.
+// Input:
+//        archive     *string		-- base64 archive compressed with from
+//        from         Compression	-- current format of archive
+//        to           Compression	-- format of the result; not CompressionBzip2
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         string		-- base64 archive compressed with to
+//         err			-- Present only if error is encountered
+func Recompress(archive *string, from, to Compression, opts ...Option) (recompressed string, err error) {
+	const archiveName = "archive"
+	cfg := optionsConfig(opts)
+	decompress, err := from.decompressor()
+	if err != nil {
+		return "", &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: err}
+	}
+	cfg.decompress, cfg.format = decompress, from.String()
+
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return "", err
+	}
+	raw, err := openStream(rdata, archiveName, cfg)
+	if err != nil {
+		return "", err
+	}
+	// The compressor is made only once there is a stream to feed it, and
+	// closed on every path after, since a zstd encoder holds resources until closed.
+	var buf bytes.Buffer
+	w, err := to.compressor(&buf)
+	if err != nil {
+		closeStream(raw, nil)
+		return "", &ExtractError{Op: OpArgument, ArchiveName: archiveName, Err: err}
+	}
+	err = guard(archiveName, cfg, func() error {
+		_, err := io.Copy(w, raw)
+		if cerr := closeStream(raw, nil); err == nil && cerr != nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		w.Close()
+		return "", streamError(cfg.format, archiveName, "", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Normalize(*string, ...Option) -- Repacks a base64 TGZ archive so equal contents always give an equal string.
+// Entries are sorted by name, with hardlinks after all other entries so
+// their targets still come first.  Every header's times are zeroed, uid and
+// gid are set to 0 with empty owner names, and the gzip stream is written at
+// gzip.BestCompression with no name or timestamp.  Entry bodies are held in
+// memory while repacking.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxTotalSize
+// Output:
+//         string		-- normalized base64 TGZ archive
+//         err			-- Present only if error is encountered
+func Normalize(archive *string, opts ...Option) (string, error) {
+	type entry struct {
+		header *tar.Header
+		data   []byte
+	}
+	var entries []entry
+
+	err := walkArchive(archive, "archive", optionsConfig(opts), func(fileHeader *tar.Header, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{header: fileHeader, data: data})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		iLink := entries[i].header.Typeflag == tar.TypeLink
+		jLink := entries[j].header.Typeflag == tar.TypeLink
+		if iLink != jLink {
+			return jLink
+		}
+		return entries[i].header.Name < entries[j].header.Name
+	})
+
+	var buf bytes.Buffer
+	gz, err := newPackWriter(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := e.header
+		hdr.ModTime = time.Unix(0, 0)
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+		for _, key := range []string{"mtime", "atime", "ctime", "uid", "gid", "uname", "gname"} {
+			delete(hdr.PAXRecords, key)
+		}
+		hdr.Format = tar.FormatUnknown
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return "", err
+		}
+	}
+	return finishPack(&buf, gz, tw)
+}
@@ -0,0 +1,274 @@
+/*****************************************************************/
+/* pack_test.go -- Tests building base64 TGZ archives.           */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// packSource(*testing.T) -- Creates a directory to pack, with a symlink that PackDir skips
+func packSource(t *testing.T) string {
+	t.Helper()
+	src := t.TempDir()
+	for name, body := range map[string]string{"a.txt": "alpha", "sub/b.txt": "beta", "sub/deeper/c.txt": ""} {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestPackDir(t *testing.T) {
+	src := packSource(t)
+	archive, err := PackDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ListFiles(&archive, "packed")
+	if want := []string{"a.txt", "sub/", "sub/b.txt", "sub/deeper/", "sub/deeper/c.txt"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("packed %q, %v; want %q, symlinks skipped", names, err, want)
+	}
+	got, err := ExtractAll(&archive, "packed")
+	if want := files("a.txt", "alpha", "sub/b.txt", "beta", "sub/deeper/c.txt", ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractAll() = %q, %v; want %q", got, err, want)
+	}
+	for name, mode := range map[string]int64{"sub/b.txt": 0640, "sub/": 0755} {
+		header, err := StatFile(&archive, "packed", name)
+		if err != nil || header.Mode&0777 != mode {
+			t.Errorf("%s header %+v, %v; want mode %o", name, header, err, mode)
+		}
+	}
+
+	// The extracted tree matches the source, less the symlink.
+	dest := t.TempDir()
+	if err := ExtractToDir(&archive, "packed", dest); err != nil {
+		t.Fatal(err)
+	}
+	if want := readTree(t, src); !reflect.DeepEqual(readTree(t, dest), want) {
+		t.Errorf("round trip gave %q, want %q", readTree(t, dest), want)
+	}
+
+	if _, err := PackDir(filepath.Join(src, "missing")); !os.IsNotExist(err) {
+		t.Errorf("PackDir(missing) error = %v, want one for a missing directory", err)
+	}
+}
+
+func TestPackDirLevel(t *testing.T) {
+	src := packSource(t)
+	for _, level := range []int{gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression} {
+		archive, err := PackDirLevel(src, level)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		names, err := ListFiles(&archive, "packed")
+		if want := []string{"a.txt", "sub/", "sub/b.txt", "sub/deeper/", "sub/deeper/c.txt"}; err != nil || !reflect.DeepEqual(names, want) {
+			t.Errorf("level %d: packed %q, %v; want %q, symlinks skipped", level, names, err, want)
+		}
+	}
+	for _, level := range []int{-2, 10} {
+		if _, err := PackDirLevel(src, level); err == nil {
+			t.Errorf("PackDirLevel(%d) succeeded, want an out-of-range error", level)
+		}
+	}
+}
+
+func TestPackFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string][]byte
+		names   []string
+	}{
+		{name: "sorted by name", entries: files("b/two.txt", "2", "a.txt", "1", "empty", ""), names: []string{"a.txt", "b/two.txt", "empty"}},
+		{name: "binary contents", entries: map[string][]byte{"bin": {0, 1, 0xff}}, names: []string{"bin"}},
+		{name: "no files", entries: map[string][]byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := PackFiles(tt.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers, err := ListEntries(&archive, "packed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, h := range headers {
+				names = append(names, h.Name)
+				if h.Typeflag != tar.TypeReg || h.Mode != 0644 || h.ModTime.Unix() != 0 {
+					t.Errorf("%s: type %c, mode %o, mtime %v; want a 0644 file at the epoch", h.Name, h.Typeflag, h.Mode, h.ModTime)
+				}
+			}
+			if !equalStrings(names, tt.names) {
+				t.Errorf("packed %q, want %q", names, tt.names)
+			}
+			got, err := ExtractAll(&archive, "packed")
+			if err != nil || len(got) != len(tt.entries) || (len(got) > 0 && !reflect.DeepEqual(got, tt.entries)) {
+				t.Errorf("ExtractAll() = %q, %v; want %q", got, err, tt.entries)
+			}
+		})
+	}
+
+	// Map order is random, so packing equal maps several times shows the
+	// output does not depend on it.
+	first, err := PackFiles(files("b/two.txt", "2", "a.txt", "1", "empty", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if again, err := PackFiles(files("empty", "", "a.txt", "1", "b/two.txt", "2")); err != nil || again != first {
+			t.Fatalf("packing equal maps gave different archives: %v", err)
+		}
+	}
+	if _, err := PackFilesLevel(files("a.txt", "1"), 42); err == nil {
+		t.Error("PackFilesLevel(42) succeeded, want an out-of-range error")
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	raw := tarBytes(t, sampleEntries()...)
+	plain := base64.StdEncoding.EncodeToString(raw)
+	sources := map[Compression]string{
+		CompressionGzip: base64.StdEncoding.EncodeToString(gzipBytes(t, raw)),
+		CompressionZstd: zstdFixture(t, sampleEntries()...),
+		CompressionXz:   xzFixture(t, sampleEntries()...),
+		CompressionNone: plain,
+	}
+	for from, archive := range sources {
+		for _, to := range []Compression{CompressionGzip, CompressionZstd, CompressionXz, CompressionNone} {
+			archive, from, to := archive, from, to
+			t.Run(from.String()+" to "+to.String(), func(t *testing.T) {
+				converted, err := Recompress(&archive, from, to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ExtractFileAuto(&converted, "converted", "app/copy.json")
+				if err != nil || string(got) != `{"debug":true}` {
+					t.Errorf("ExtractFileAuto() = %q, %v", got, err)
+				}
+				// The tar stream, and so every header, comes through byte for byte.
+				if back, err := Recompress(&converted, to, CompressionNone); err != nil || back != plain {
+					t.Errorf("tar stream changed: %v", err)
+				}
+			})
+		}
+	}
+	t.Run("bzip2 to zstd", func(t *testing.T) {
+		bzip2 := bzip2Archive
+		converted, err := Recompress(&bzip2, CompressionBzip2, CompressionZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ExtractFileZstd(&converted, "converted", "a.txt"); err != nil || string(got) != "bzip2 body\n" {
+			t.Errorf("ExtractFileZstd() = %q, %v", got, err)
+		}
+	})
+
+	gzipped := sources[CompressionGzip]
+	truncated := base64.StdEncoding.EncodeToString(gzipBytes(t, raw)[:100])
+	corrupt := "!!!!"
+	errTests := []struct {
+		name     string
+		archive  *string
+		from, to Compression
+		wantOp   string
+	}{
+		{name: "to bzip2", archive: &gzipped, from: CompressionGzip, to: CompressionBzip2, wantOp: OpArgument},
+		{name: "unknown target", archive: &gzipped, from: CompressionGzip, to: Compression(42), wantOp: OpArgument},
+		{name: "unknown source", archive: &gzipped, from: Compression(42), to: CompressionGzip, wantOp: OpArgument},
+		{name: "wrong source format", archive: &gzipped, from: CompressionXz, to: CompressionGzip, wantOp: OpXz},
+		{name: "truncated source", archive: &truncated, from: CompressionGzip, to: CompressionZstd, wantOp: OpGzip},
+		{name: "bad base64", archive: &corrupt, from: CompressionGzip, to: CompressionZstd, wantOp: OpDecode},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Recompress(tt.archive, tt.from, tt.to, quiet)
+			checkExtractError(t, err, tt.wantOp, nil)
+			if got != "" {
+				t.Errorf("Recompress() = %q, want \"\" on error", got)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	owned := func(e entry, uid int, mtime time.Time) entry {
+		e.hdr.Uid, e.hdr.Uname, e.hdr.ModTime = uid, "user", mtime
+		return e
+	}
+	later := fixtureTime.Add(48 * time.Hour)
+	a := fixture(t, fileEntry("b.txt", "b"), hardlinkEntry("c.txt", "b.txt"), dirEntry("d/"), fileEntry("a.txt", "a"))
+	b := fixture(t, owned(dirEntry("d/"), 7, later), owned(fileEntry("a.txt", "a"), 7, later),
+		owned(fileEntry("b.txt", "b"), 7, later), owned(hardlinkEntry("c.txt", "b.txt"), 7, later))
+
+	normalA, err := Normalize(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalB, err := Normalize(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normalA != normalB {
+		t.Error("archives differing only in order, times and owners normalize differently")
+	}
+	if again, err := Normalize(&normalA); err != nil || again != normalA {
+		t.Errorf("normalizing twice changed the archive: %v", err)
+	}
+
+	headers, err := ListEntries(&normalA, "normalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, h := range headers {
+		names = append(names, h.Name)
+		if h.Uid != 0 || h.Uname != "" || h.ModTime.Unix() != 0 {
+			t.Errorf("%s keeps uid %d, uname %q, mtime %v", h.Name, h.Uid, h.Uname, h.ModTime)
+		}
+	}
+	// Hardlinks sort last so their targets still precede them.
+	if want := []string{"a.txt", "b.txt", "d/", "c.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("normalized order %q, want %q", names, want)
+	}
+	if got, err := Extract(&normalA, "normalized", "c.txt"); err != nil || string(got) != "b" {
+		t.Errorf("Extract(c.txt) = %q, %v; want the hardlinked b", got, err)
+	}
+	meta, err := GzipMetadata(&normalA)
+	if err != nil || meta.Name != "" || !meta.ModTime.IsZero() {
+		t.Errorf("gzip header %+v, %v; want no name or time", meta, err)
+	}
+}
@@ -0,0 +1,325 @@
+/*****************************************************************/
+/* stream.go -- Streaming access to single entries of a          */
+/* base64 TGZ archive.                                           */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/TwiN/go-color"
+)
+
+// entryReader reads one tar entry body and releases the decoding chain on Close.
+type entryReader struct {
+	tarDat      *tar.Reader
+	raw         io.Reader
+	archiveName string
+	filePath    string
+	cfg         *config
+}
+
+// Read([]byte) -- Reads from the entry body
+func (e *entryReader) Read(p []byte) (n int, err error) {
+	defer recoverCorrupt(e.archiveName, e.cfg, &err)
+	n, err = e.tarDat.Read(p)
+	if err != nil && err != io.EOF {
+		err = streamError(OpTar, e.archiveName, e.filePath, err)
+	}
+	return n, err
+}
+
+// Close() -- Releases the decompressor
+func (e *entryReader) Close() error {
+	return closeStream(e.raw, nil)
+}
+
+// OpenFile(*string, string, string, ...Option) -- Opens a file in a base64 TGZ archive for streaming.
+// The decoded archive is kept in memory but the file body is only read as
+// the caller reads from the returned reader.  The caller must Close it.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         io.ReadCloser	-- reader over the file body
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func OpenFile(archive *string, archiveName, filePath string, opts ...Option) (io.ReadCloser, error) {
+	rc, _, err := openEntry(archive, archiveName, filePath, optionsConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// ExtractToWriter(*string, string, string, io.Writer, ...Option) -- Copies a file from a base64 TGZ archive to w.
+// The file body is streamed to w rather than buffered.  A hardlink is
+// followed to the earlier entry it links to, at the cost of a second walk.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        w            io.Writer	-- destination of the file body
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithMaxSize, WithProgress;
+//                                 progress totals are the file's declared size
+// Output:
+//         int64		-- Bytes copied to w
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+//                         or ErrSizeExceeded if the file is over MaxSize
+func ExtractToWriter(archive *string, archiveName, filePath string, w io.Writer, opts ...Option) (int64, error) {
+	cfg := optionsConfig(opts)
+	if err := checkBufferSize(archiveName, cfg); err != nil {
+		return 0, err
+	}
+	var written int64
+	found := false
+	var link *tar.Header
+	seen := make(map[string]bool)
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		if !sameEntry(fileHeader.Name, filePath) {
+			seen[fsName(fileHeader.Name)] = true
+			return nil
+		}
+		found = true
+		if fileHeader.Typeflag == tar.TypeLink {
+			link = fileHeader
+			return ErrStopWalk
+		}
+		if cfg.progress != nil {
+			r = &progressReader{r: r, done: new(int64), total: fileHeader.Size, progress: cfg.progress}
+		}
+		n, err := copyEntry(w, r, fileHeader, archiveName, cfg)
+		written = n
+		if err != nil {
+			return err
+		}
+		return ErrStopWalk
+	})
+	if err != nil {
+		return written, err
+	}
+	if !found {
+		cfg.debugf("%s Reached end of %s tarball read.", cfg.paint(color.Cyan, cfg.logName), archiveName)
+		return 0, notFound(archiveName, filePath)
+	}
+	if link != nil {
+		if !seen[fsName(link.Linkname)] {
+			return 0, &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: link.Name, Err: fmt.Errorf("hardlink points to %q, which does not precede it", link.Linkname)}
+		}
+		return ExtractToWriter(archive, archiveName, link.Linkname, w, opts...)
+	}
+	return written, nil
+}
+
+// ExtractRange(*string, string, string, int64, int64, ...Option) -- Reads a byte range of a file in a base64 TGZ archive.
+// Tar streams cannot seek, so the first off bytes of the entry are read and
+// discarded; the cost is linear in off + length.  Reading stops at the end of
+// the range, so the rest of the archive is not decompressed.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        filePath     string		-- path of the file within the archive
+//        off          int64		-- offset of the first byte to return
+//        length       int64		-- number of bytes to return; fewer are
+//                                 returned if the file ends first
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithEncoding
+// Output:
+//         []byte		-- File data in [off, off+length), empty if off is
+//                         past the end of the file
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if filePath is not in the archive
+func ExtractRange(archive *string, archiveName, filePath string, off, length int64, opts ...Option) ([]byte, error) {
+	if off < 0 || length < 0 {
+		return nil, &ExtractError{Op: OpArgument, ArchiveName: archiveName, FilePath: filePath, Err: fmt.Errorf("invalid range [%d, +%d)", off, length)}
+	}
+	rc, _, err := openEntry(archive, archiveName, filePath, optionsConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, rc, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return ioutil.ReadAll(io.LimitReader(rc, length))
+}
+
+// openEntry(*string, string, string, *config) -- Positions a tar reader at the named entry
+// A hardlink is followed by reopening the archive at the entry it links to.
+// Input:
+//         archive     *string		-- base64 archive
+//         archiveName  string		-- archive name used in logs and errors
+//         filePath     string		-- path of the file within the archive
+//         cfg         *config		-- decoding settings
+// Output:
+//         *entryReader		-- reader over the entry body, must be closed
+//         *tar.Header		-- header of the entry
+//         err          error		-- wraps ErrFileNotFound if filePath is not in the archive
+func openEntry(archive *string, archiveName, filePath string, cfg *config) (rc *entryReader, header *tar.Header, err error) {
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := openStream(rdata, archiveName, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	owned := false // set once the returned entryReader owns raw
+	defer func() {
+		if !owned {
+			closeStream(raw, nil)
+		}
+	}()
+	tarDat := tar.NewReader(raw)
+	seen := make(map[string]bool)
+
+	for {
+		fileHeader, err := nextHeader(tarDat, archiveName, cfg)
+		if err == io.EOF {
+			cfg.debugf("%s Reached end of %s tarball read.", cfg.paint(color.Cyan, cfg.logName), archiveName)
+			return nil, nil, notFound(archiveName, filePath)
+		}
+		if err != nil {
+			cfg.logf("%s %s", cfg.paint(color.Cyan, cfg.logName), cfg.paint(color.Red, "ERROR -- CANNOT READ "+archiveName+"!!!"))
+			return nil, nil, streamError(OpTar, archiveName, "", err)
+		}
+		if !sameEntry(fileHeader.Name, filePath) {
+			seen[fsName(fileHeader.Name)] = true
+			continue
+		}
+		if fileHeader.Typeflag == tar.TypeLink {
+			if !seen[fsName(fileHeader.Linkname)] {
+				return nil, nil, &ExtractError{Op: OpLink, ArchiveName: archiveName, FilePath: fileHeader.Name, Err: fmt.Errorf("hardlink points to %q, which does not precede it", fileHeader.Linkname)}
+			}
+			return openEntry(archive, archiveName, fileHeader.Linkname, cfg)
+		}
+		owned = true
+		return &entryReader{tarDat: tarDat, raw: raw, archiveName: archiveName, filePath: filePath, cfg: cfg}, fileHeader, nil
+	}
+}
+
+// Iterator steps through the entries of a base64 TGZ archive, in the manner
+// of bufio.Scanner:
+//     it, err := NewIterator(&archive, "assets")
+//     ...
+//     for it.Next() {
+//         hdr := it.Header()
+//         // read hdr's body from it
+//     }
+//     if err := it.Err(); err != nil { ... }
+// The decompressor is released when Next returns false, or by Close.
+type Iterator struct {
+	tarDat      *tar.Reader
+	raw         io.Reader
+	archiveName string
+	cfg         *config
+	header      *tar.Header
+	err         error
+	done        bool
+}
+
+// NewIterator(*string, string, ...Option) -- Opens a base64 TGZ archive for iteration.
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxEntries
+// Output:
+//         *Iterator		-- iterator positioned before the first entry
+//         err			-- Present only if error is encountered
+func NewIterator(archive *string, archiveName string, opts ...Option) (*Iterator, error) {
+	cfg := optionsConfig(opts)
+	rdata, err := decodeArchive(archive, archiveName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := openStream(rdata, archiveName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{tarDat: tar.NewReader(raw), raw: raw, archiveName: archiveName, cfg: cfg}, nil
+}
+
+// Next() -- Advances to the next entry; false at the end of the archive or on error
+func (it *Iterator) Next() (more bool) {
+	if it.done {
+		return false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			it.err = corruptError(it.archiveName, it.cfg, r)
+			it.header, it.done, more = nil, true, false
+			closeStream(it.raw, nil)
+		}
+	}()
+	header, err := it.tarDat.Next()
+	if err != nil {
+		if err == io.EOF {
+			// Reading to EOF checks trailers such as the gzip CRC-32.
+			if cerr := closeStream(it.raw, it.raw); cerr != nil {
+				it.err = streamError(OpGzip, it.archiveName, "", cerr)
+			}
+		} else {
+			closeStream(it.raw, nil)
+			it.err = streamError(OpTar, it.archiveName, "", err)
+		}
+		it.header, it.done = nil, true
+		return false
+	}
+	it.header = header
+	return true
+}
+
+// Header() -- Returns the header of the current entry, nil before Next or after the end
+func (it *Iterator) Header() *tar.Header {
+	return it.header
+}
+
+// Read([]byte) -- Reads from the body of the current entry
+func (it *Iterator) Read(p []byte) (n int, err error) {
+	if it.header == nil {
+		return 0, io.EOF
+	}
+	defer recoverCorrupt(it.archiveName, it.cfg, &err)
+	n, err = it.tarDat.Read(p)
+	if err != nil && err != io.EOF {
+		err = streamError(OpTar, it.archiveName, it.header.Name, err)
+	}
+	return n, err
+}
+
+// Err() -- Returns the first error met by Next, nil at a clean end of the archive
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close() -- Releases the decompressor early; needless once Next has returned false
+func (it *Iterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.header, it.done = nil, true
+	return closeStream(it.raw, nil)
+}
@@ -0,0 +1,319 @@
+/*****************************************************************/
+/* stream_test.go -- Tests streaming access to single entries of */
+/* a base64 TGZ archive.                                         */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts n bytes and then fails.
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		written := w.n
+		w.n = 0
+		return written, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestExtractToWriter(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	big := strings.Repeat("0123456789", 10000)
+	large := fixture(t, fileEntry("big.txt", big))
+	tests := []struct {
+		name    string
+		archive *string
+		file    string
+		opts    []Option
+		want    string
+	}{
+		{name: "file", archive: archive, file: "app/readme.txt", want: "hello"},
+		{name: "hardlink", archive: archive, file: "app/copy.json", want: `{"debug":true}`},
+		{name: "small buffer", archive: archive, file: "app/readme.txt", opts: []Option{WithBufferSize(2)}, want: "hello"},
+		{name: "large file", archive: large, file: "big.txt", want: big},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := ExtractToWriter(tt.archive, "fixture", tt.file, &buf, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(tt.want)) || buf.String() != tt.want {
+				t.Errorf("wrote %d bytes, %d returned; want %d", buf.Len(), n, len(tt.want))
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	n, err := ExtractToWriter(archive, "fixture", "nope", &buf, quiet)
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+	if n != 0 || buf.Len() != 0 {
+		t.Errorf("wrote %d bytes for a missing file", buf.Len())
+	}
+
+	// An error from w is returned as is, with the bytes written before it.
+	n, err = ExtractToWriter(large, "fixture", "big.txt", &failingWriter{n: 1000})
+	if err == nil || err.Error() != "disk full" || n != 1000 {
+		t.Errorf("ExtractToWriter() to a failing writer = %d, %v; want 1000, disk full", n, err)
+	}
+}
+
+func TestExtractRange(t *testing.T) {
+	big := strings.Repeat("0123456789", 10000)
+	large := fixture(t, fileEntry("big.txt", big), hardlinkEntry("link.txt", "big.txt"))
+	end := int64(len(big))
+	tests := []struct {
+		name        string
+		file        string
+		off, length int64
+		want        string
+	}{
+		{name: "middle", file: "big.txt", off: 12345, length: 7, want: "5678901"},
+		{name: "start", file: "big.txt", off: 0, length: 3, want: "012"},
+		{name: "whole file", file: "big.txt", off: 0, length: end, want: big},
+		{name: "past end of file", file: "big.txt", off: end - 3, length: 10, want: "789"},
+		{name: "at end of file", file: "big.txt", off: end, length: 10, want: ""},
+		{name: "beyond file", file: "big.txt", off: end + 1, length: 10, want: ""},
+		{name: "empty", file: "big.txt", off: 5, length: 0, want: ""},
+		{name: "hardlink", file: "link.txt", off: 10, length: 4, want: "0123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractRange(large, "fixture", tt.file, tt.off, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || string(got) != tt.want {
+				t.Errorf("ExtractRange(%d, %d) = %d bytes, want %q", tt.off, tt.length, len(got), tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []struct {
+		name        string
+		file        string
+		off, length int64
+		wantOp      string
+		wantErr     error
+	}{
+		{name: "missing", file: "nope", off: 0, length: 1, wantOp: OpNotFound, wantErr: ErrFileNotFound},
+		{name: "negative offset", file: "big.txt", off: -1, length: 1, wantOp: OpArgument},
+		{name: "negative length", file: "big.txt", off: 0, length: -1, wantOp: OpArgument},
+	} {
+		t.Run(bad.name, func(t *testing.T) {
+			_, err := ExtractRange(large, "fixture", bad.file, bad.off, bad.length, quiet)
+			checkExtractError(t, err, bad.wantOp, bad.wantErr)
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	big := strings.Repeat("0123456789", 10000)
+	large := fixture(t, fileEntry("big.txt", big), fileEntry("after.txt", "after"))
+	tests := []struct {
+		name    string
+		archive *string
+		file    string
+		want    string
+	}{
+		{name: "file", archive: archive, file: "app/readme.txt", want: "hello"},
+		{name: "leading ./", archive: archive, file: "./app/readme.txt", want: "hello"},
+		{name: "hardlink", archive: archive, file: "app/copy.json", want: `{"debug":true}`},
+		{name: "large file", archive: large, file: "big.txt", want: big},
+		{name: "entry after a large file", archive: large, file: "after.txt", want: "after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := OpenFile(tt.archive, "fixture", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			// Read in small chunks, as a caller streaming the body would.
+			var got bytes.Buffer
+			chunk := make([]byte, 7)
+			for {
+				n, err := rc.Read(chunk)
+				if n > len(chunk) {
+					t.Fatalf("Read returned %d bytes into a %d byte buffer", n, len(chunk))
+				}
+				got.Write(chunk[:n])
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("read %d bytes, want %d", got.Len(), len(tt.want))
+			}
+			if err := rc.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+		})
+	}
+
+	rc, err := OpenFile(archive, "fixture", "nope", quiet)
+	if rc != nil {
+		t.Error("OpenFile(missing) returned a reader")
+	}
+	checkExtractError(t, err, OpNotFound, ErrFileNotFound)
+}
+
+func TestExtractToWriterProgress(t *testing.T) {
+	body := strings.Repeat("x", 100<<10)
+	archive := fixture(t, fileEntry("big.bin", body))
+	var calls [][2]int64
+	n, err := ExtractToWriter(archive, "fixture", "big.bin", ioutil.Discard, WithBufferSize(4<<10), WithProgress(func(done, total int64) {
+		calls = append(calls, [2]int64{done, total})
+	}))
+	if err != nil || n != int64(len(body)) {
+		t.Fatalf("ExtractToWriter() = %d, %v", n, err)
+	}
+	if len(calls) < 2 {
+		t.Fatalf("progress called %d times, want one per buffer", len(calls))
+	}
+	for i, c := range calls {
+		if c[1] != int64(len(body)) || (i > 0 && c[0] < calls[i-1][0]) {
+			t.Fatalf("progress call %d = %v, want rising totals of %d", i, c, len(body))
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != int64(len(body)) {
+		t.Errorf("last progress call = %v, want done = %d", last, len(body))
+	}
+}
+
+// BenchmarkBufferSize compares ExtractToWriter's default io.Copy with
+// WithBufferSize buffers from tiny to larger than the file.
+func BenchmarkBufferSize(b *testing.B) {
+	body := string(payload(1 << 20))
+	archive := fixture(b, fileEntry("data", body))
+	sizes := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "default", opts: []Option{quiet}},
+		{name: "512B", opts: []Option{quiet, WithBufferSize(512)}},
+		{name: "4KiB", opts: []Option{quiet, WithBufferSize(4 << 10)}},
+		{name: "32KiB", opts: []Option{quiet, WithBufferSize(32 << 10)}},
+		{name: "256KiB", opts: []Option{quiet, WithBufferSize(256 << 10)}},
+		{name: "2MiB", opts: []Option{quiet, WithBufferSize(2 << 20)}},
+	}
+	for _, s := range sizes {
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ExtractToWriter(archive, "bench", "data", ioutil.Discard, s.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestIterator(t *testing.T) {
+	archive := fixture(t, sampleEntries()...)
+	it, err := NewIterator(archive, "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Header() != nil {
+		t.Error("Header() before Next is not nil")
+	}
+	if n, err := it.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read() before Next = %d, %v; want io.EOF", n, err)
+	}
+
+	var got []string
+	for i := 0; it.Next(); i++ {
+		if i == 2 {
+			continue // leaving a body unread must not upset the next header
+		}
+		body, err := ioutil.ReadAll(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, it.Header().Name+"="+string(body))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"app/=", `app/config.json={"debug":true}`, "app/latest=", "app/copy.json=", "./app-b/run.sh=#!/bin/sh\n",
+		"logs/2023-01-02.txt=jan", "logs/2023-1-2.txt=odd", "logs/notes.md=# notes", "img/logo=\x89PNG\r\n\x1a\n",
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("iterated %q, want %q", got, want)
+	}
+	if it.Next() || it.Header() != nil {
+		t.Error("Next() after the end advanced")
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("Close() after the end = %v", err)
+	}
+}
+
+func TestIteratorErrors(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(*fixture(t, fileEntry("a.txt", "a")))
+	raw[len(raw)-8] ^= 0xff
+	badCRC := base64.StdEncoding.EncodeToString(raw)
+	it, err := NewIterator(&badCRC, "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+	}
+	checkExtractError(t, it.Err(), OpGzip, gzip.ErrChecksum)
+
+	notGzip := base64.StdEncoding.EncodeToString([]byte("plain text"))
+	if _, err := NewIterator(&notGzip, "fixture"); err == nil {
+		t.Error("NewIterator() accepted a stream that is not gzip")
+	}
+
+	// Closing early releases the stream, and Next then stops cleanly.
+	it, err = NewIterator(fixture(t, sampleEntries()...), "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if it.Next() || it.Err() != nil {
+		t.Errorf("Next() after Close advanced, or set Err() = %v", it.Err())
+	}
+}
@@ -0,0 +1,171 @@
+/*****************************************************************/
+/* version.go -- Selection of semantically versioned entries     */
+/* from a base64 TGZ archive.                                    */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"archive/tar"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semverPattern matches what follows the prefix of a versioned entry name:
+// an optional "v", MAJOR.MINOR.PATCH, an optional pre-release, and any
+// extensions, e.g. "1.3.1.bin" or "v2.0.0-rc.1.tar.gz".
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*?))?((?:\.[A-Za-z][0-9A-Za-z]*)*)$`)
+
+// semver is a parsed semantic version; build metadata is not supported.
+type semver struct {
+	major, minor, patch uint64
+	pre                 []string
+}
+
+// ExtractLatest(*string, string, string, ...Option) -- Extracts the entry with the highest semantic version after prefix.
+// Regular files named prefix + version + optional extensions are compared
+// by semver precedence; names whose version does not parse are ignored.
+// If two entries share the highest version, the first one wins.  Names and
+// prefix are cleaned as in FS, so "bin/app-" also matches "./bin/app-1.0.0".
+// Input:
+//        archive     *string		-- base64 TGZ archive
+//        archiveName  string		-- archive name used in logs and errors
+//        prefix       string		-- name up to the version, e.g. "bin/app-"
+//        opts      ...Option		-- OPTIONAL settings, e.g. WithSilent, WithMaxSize
+// Output:
+//         []byte		-- File data of the chosen entry
+//         string		-- Name of the chosen entry
+//         err			-- Present only if error is encountered; wraps
+//                         ErrFileNotFound if no entry has a valid version
+func ExtractLatest(archive *string, archiveName, prefix string, opts ...Option) ([]byte, string, error) {
+	cfg := optionsConfig(opts)
+	var fileData []byte
+	var name string
+	var best *semver
+
+	// fsName drops a trailing slash, which is part of a directory prefix.
+	want := fsName(prefix)
+	if want == "." {
+		want = ""
+	} else if strings.HasSuffix(prefix, "/") {
+		want += "/"
+	}
+
+	err := walkArchive(archive, archiveName, cfg, func(fileHeader *tar.Header, r io.Reader) error {
+		entryName := fsName(fileHeader.Name)
+		if !isRegular(fileHeader) || !strings.HasPrefix(entryName, want) {
+			return nil
+		}
+		version, ok := parseSemver(entryName[len(want):])
+		if !ok || (best != nil && version.compare(best) <= 0) {
+			return nil
+		}
+		data, err := readEntry(r, fileHeader, archiveName, cfg)
+		if err != nil {
+			return err
+		}
+		fileData, name, best = data, fileHeader.Name, version
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if best == nil {
+		return nil, "", notFound(archiveName, prefix)
+	}
+	return fileData, name, nil
+}
+
+// parseSemver(string) -- Parses the version at the start of s
+// Output:
+//         *semver		-- the version
+//         bool			-- false if s does not match semverPattern
+func parseSemver(s string) (*semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	version := &semver{}
+	var err error
+	if version.major, err = strconv.ParseUint(m[1], 10, 64); err != nil {
+		return nil, false
+	}
+	if version.minor, err = strconv.ParseUint(m[2], 10, 64); err != nil {
+		return nil, false
+	}
+	if version.patch, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+		return nil, false
+	}
+	if m[4] != "" {
+		version.pre = strings.Split(m[4], ".")
+	}
+	return version, true
+}
+
+// compare(*semver) -- Returns -1, 0, or 1 as v has lower, equal, or higher precedence than o
+func (v *semver) compare(o *semver) int {
+	for _, pair := range [][2]uint64{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if c := compareUint(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+
+	// A release outranks any of its pre-releases.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		if c := comparePreRelease(v.pre[i], o.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.pre)), uint64(len(o.pre)))
+}
+
+// comparePreRelease(string, string) -- Compares pre-release identifiers: numbers numerically and below words
+func comparePreRelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareUint(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareUint(uint64, uint64) -- Returns -1, 0, or 1 as a is less than, equal to, or greater than b
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+/*****************************************************************/
+/* version_test.go -- Tests the selection of semantically        */
+/* versioned entries from a base64 TGZ archive.                  */
+/*                                                               */
+/* Written by Matt Rienzo                                        */
+/*---------------------------------------------------------------*/
+/* Copyright 2022 Matt Rienzo                                    */
+/*                                                               */
+/* Licensed under the Apache License, Version 2.0 (the           */
+/* "License"); you may not use this file except in compliance    */
+/* with the license.  You may obtain a copy of the license at    */
+/*    http://www.apache.org/licenses/LICENSE-2.0                 */
+/* Unless required by applicable law or agreed to in writing,    */
+/* software distributed under the License is distributed on an   */
+/* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,  */
+/* either express or implied.  See the License for specific      */
+/* language governing permissions and limitations under the      */
+/* License.                                                      */
+/*****************************************************************/
+
+package SelfTGZ
+
+import (
+	"testing"
+)
+
+func TestExtractLatest(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string // entry names; each body is its own name
+		prefix  string
+		want    string
+		wantErr error
+	}{
+		{name: "numeric, not lexical", names: []string{"app-1.2.0.bin", "app-1.10.0.bin", "app-1.9.9.bin"}, prefix: "app-", want: "app-1.10.0.bin"},
+		{name: "major wins", names: []string{"app-2.0.0", "app-10.0.0", "app-9.99.99"}, prefix: "app-", want: "app-10.0.0"},
+		{name: "release beats pre-release", names: []string{"app-2.0.0-rc.2", "app-2.0.0", "app-2.0.0-rc.10"}, prefix: "app-", want: "app-2.0.0"},
+		{name: "numeric pre-release identifiers", names: []string{"app-2.0.0-rc.2", "app-2.0.0-rc.10", "app-1.0.0"}, prefix: "app-", want: "app-2.0.0-rc.10"},
+		{name: "words above numbers", names: []string{"app-1.0.0-alpha", "app-1.0.0-1", "app-1.0.0-0.9"}, prefix: "app-", want: "app-1.0.0-alpha"},
+		{name: "longer pre-release wins", names: []string{"app-1.0.0-alpha.1.bin", "app-1.0.0-alpha.bin"}, prefix: "app-", want: "app-1.0.0-alpha.1.bin"},
+		{name: "v prefix and extensions", names: []string{"bin/tool-v1.2.3.tar.gz", "bin/tool-v1.2.4.tar.gz", "bin/tool-latest"}, prefix: "bin/tool-", want: "bin/tool-v1.2.4.tar.gz"},
+		{name: "unparsable names ignored", names: []string{"app-1.2", "app-01.2.3", "app-1.2.3", "app-x.y.z"}, prefix: "app-", want: "app-1.2.3"},
+		{name: "first of equal versions", names: []string{"app-1.0.0.bin", "app-1.0.0.exe"}, prefix: "app-", want: "app-1.0.0.bin"},
+		{name: "other prefixes ignored", names: []string{"lib-9.0.0", "app-1.0.0"}, prefix: "app-", want: "app-1.0.0"},
+		{name: "entries with leading ./", names: []string{"./bin/app-1.0.0", "./bin/app-2.0.0"}, prefix: "bin/app-", want: "./bin/app-2.0.0"},
+		{name: "mixed leading ./", names: []string{"./bin/app-1.0.0", "bin/app-1.2.0"}, prefix: "bin/app-", want: "bin/app-1.2.0"},
+		{name: "prefix with leading ./", names: []string{"bin/app-1.0.0"}, prefix: "./bin/app-", want: "bin/app-1.0.0"},
+		{name: "prefix with leading slash", names: []string{"./bin/app-1.0.0"}, prefix: "/bin/app-", want: "./bin/app-1.0.0"},
+		{name: "directory prefix", names: []string{"./tools/1.0.0", "tools/2.0.0", "tools-3.0.0"}, prefix: "./tools/", want: "tools/2.0.0"},
+		{name: "empty prefix", names: []string{"1.0.0", "./2.0.0"}, prefix: "", want: "./2.0.0"},
+		{name: "no versions", names: []string{"app-latest", "readme"}, prefix: "app-", wantErr: ErrFileNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := []entry{dirEntry("app-9.9.9/")}
+			for _, name := range tt.names {
+				entries = append(entries, fileEntry(name, name))
+			}
+			data, name, err := ExtractLatest(fixture(t, entries...), "fixture", tt.prefix)
+			if tt.wantErr != nil {
+				checkExtractError(t, err, OpNotFound, tt.wantErr)
+				return
+			}
+			if err != nil || name != tt.want || string(data) != tt.want {
+				t.Errorf("ExtractLatest() = %q, %q, %v; want %q", data, name, err, tt.want)
+			}
+		})
+	}
+}